@@ -0,0 +1,18 @@
+package kigo
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+func encodeValue(value interface{}) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeValue(blob []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(blob)).Decode(value)
+}
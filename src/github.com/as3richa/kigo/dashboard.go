@@ -0,0 +1,402 @@
+package kigo
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/julienschmidt/httprouter"
+)
+
+const throughputWindow = time.Hour
+
+var dashboardTemplates = parseDashboardTemplates()
+
+var dashboardFuncs = template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"ago": func(t time.Time) time.Duration {
+		return time.Since(t).Round(time.Second)
+	},
+	"duration": func(d time.Duration) time.Duration {
+		return d.Round(time.Second)
+	},
+	"stateCount": func(counts map[JobState]uint, name string) uint {
+		state, _ := ParseJobState(name)
+		return counts[state]
+	},
+	"bulkActionLabels": func() map[string]string {
+		return map[string]string{
+			"retry":   "Retry",
+			"run":     "Run now",
+			"delete":  "Delete",
+			"requeue": "Move to queue",
+		}
+	},
+	"canWrite": func() bool {
+		return false
+	},
+	"csrfField": func() template.HTML {
+		return ""
+	},
+	"toJSON": func(value interface{}) string {
+		serialized, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(serialized)
+	},
+}
+
+func parseDashboardTemplates() map[string]*template.Template {
+	templates := map[string]*template.Template{}
+	for name, page := range dashboardPageTemplates {
+		t := template.New(name).Funcs(dashboardFuncs)
+		template.Must(t.Parse(dashboardLayoutTemplate))
+		template.Must(t.Parse(dashboardQueueTableTemplate))
+		template.Must(t.Parse(page))
+		templates[name] = t
+	}
+	return templates
+}
+
+type dashboardPage struct {
+	Title string
+	Flash string
+	Error string
+	Data  interface{}
+}
+
+type overviewData struct {
+	Finished  uint
+	Failed    uint
+	Enqueued  uint
+	Running   uint
+	Scheduled uint
+	Dead      uint
+	Queues    []QueueStats
+}
+
+type jobListData struct {
+	Path            string
+	Filter          JobFilter
+	ShowStateFilter bool
+	States          []string
+	SelectedState   string
+	Jobs            []JobInfo
+	Next            string
+	BulkParams      url.Values
+}
+
+func renderDashboard(w http.ResponseWriter, r *http.Request, name string, title string, data interface{}) {
+	page := dashboardPage{
+		Title: title,
+		Flash: r.URL.Query().Get("flash"),
+		Error: r.URL.Query().Get("error"),
+		Data:  data,
+	}
+	executeDashboard(w, r, http.StatusOK, name, page)
+}
+
+func renderDashboardError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == gorm.ErrRecordNotFound {
+		renderDashboardStatus(w, r, http.StatusNotFound, err)
+	} else {
+		renderDashboardStatus(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func renderDashboardStatus(w http.ResponseWriter, r *http.Request, status int, err error) {
+	executeDashboard(w, r, status, "queues", dashboardPage{
+		Title: http.StatusText(status),
+		Error: err.Error(),
+		Data:  []QueueStats{},
+	})
+}
+
+// executeDashboard renders a page with a copy of its template bound to the
+// request's session, so that action forms carry its CSRF token and are hidden
+// from read-only users.
+func executeDashboard(w http.ResponseWriter, r *http.Request, status int, name string, page dashboardPage) {
+	t, err := dashboardTemplates[name].Clone()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Funcs(sessionTemplateFuncs(r))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	t.ExecuteTemplate(w, "layout", page)
+}
+
+func (c Connection) defineDashboardRoutes(router *httprouter.Router) {
+	stylesheet := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Write([]byte(dashboardStylesheet))
+	}
+
+	overview := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		stats, err := c.QueueStats()
+		if err != nil {
+			renderDashboardError(w, r, err)
+			return
+		}
+
+		data := overviewData{Queues: stats}
+		for _, queueStats := range stats {
+			data.Enqueued += queueStats.Counts[JobEnqueued] - queueStats.Scheduled
+			data.Scheduled += queueStats.Scheduled
+			data.Running += queueStats.Counts[JobRunning]
+			data.Dead += queueStats.Counts[JobFailed]
+		}
+
+		if data.Finished, data.Failed, err = c.recentOutcomes(time.Now().Add(-throughputWindow)); err != nil {
+			renderDashboardError(w, r, err)
+			return
+		}
+
+		renderDashboard(w, r, "overview", "Overview", data)
+	}
+
+	queues := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		stats, err := c.QueueStats()
+		if err != nil {
+			renderDashboardError(w, r, err)
+			return
+		}
+		renderDashboard(w, r, "queues", "Queues", stats)
+	}
+
+	jobList := func(title string, fixedState *JobState, scheduled bool) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			query := r.URL.Query()
+
+			filter, err := parseJobFilter(query)
+			if err != nil {
+				renderDashboardError(w, r, err)
+				return
+			}
+
+			if fixedState != nil {
+				filter.State = fixedState
+			}
+
+			if scheduled {
+				filter.StartAtAfter = time.Now()
+			}
+
+			cursor, _ := parseUintParam(query, "after")
+
+			page, err := c.ListJobs(filter, cursor, 0)
+			if err != nil {
+				renderDashboardError(w, r, err)
+				return
+			}
+
+			data := jobListData{
+				Path:            r.URL.Path,
+				Filter:          filter,
+				ShowStateFilter: fixedState == nil,
+				SelectedState:   query.Get("state"),
+				Jobs:            page.Jobs,
+			}
+
+			data.BulkParams = url.Values{}
+			for key, values := range query {
+				if key != "after" && key != "limit" && key != "flash" && key != "error" && query.Get(key) != "" {
+					data.BulkParams[key] = values
+				}
+			}
+			if fixedState != nil {
+				data.BulkParams.Set("state", fixedState.String())
+			}
+			if scheduled {
+				data.BulkParams.Set("startAtAfter", filter.StartAtAfter.Format(time.RFC3339))
+			}
+
+			for _, state := range []JobState{JobEnqueued, JobRunning, JobFailed, JobFinished} {
+				data.States = append(data.States, state.String())
+			}
+
+			if page.Next != 0 {
+				next := url.Values{}
+				for key, values := range query {
+					next[key] = values
+				}
+				next.Set("after", strconv.FormatUint(uint64(page.Next), 10))
+				data.Next = r.URL.Path + "?" + next.Encode()
+			}
+
+			renderDashboard(w, r, "jobs", title, data)
+		}
+	}
+
+	enqueued := JobEnqueued
+	failed := JobFailed
+
+	job := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id, err := strconv.ParseUint(params.ByName("id"), 10, 64)
+		if err != nil {
+			renderDashboardError(w, r, gorm.ErrRecordNotFound)
+			return
+		}
+
+		info, err := c.FindJob(uint(id))
+		if err != nil {
+			renderDashboardError(w, r, err)
+			return
+		}
+
+		renderDashboard(w, r, "job", fmt.Sprintf("Job %d", info.ID), info)
+	}
+
+	workers := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		infos, err := c.Workers()
+		if err != nil {
+			renderDashboardError(w, r, err)
+			return
+		}
+		renderDashboard(w, r, "workers", "Workers", infos)
+	}
+
+	jobAction := func(verb string, action func(uint, *http.Request) error) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			id, err := strconv.ParseUint(params.ByName("id"), 10, 64)
+			if err != nil {
+				renderDashboardError(w, r, gorm.ErrRecordNotFound)
+				return
+			}
+
+			redirect := r.Referer()
+			if redirect == "" || verb == "deleted" {
+				redirect = "/jobs"
+			}
+
+			if err := action(uint(id), r); err != nil {
+				redirectWithMessage(w, r, redirect, "error", fmt.Sprintf("Job %d: %v", id, err))
+			} else {
+				redirectWithMessage(w, r, redirect, "flash", fmt.Sprintf("Job %d %s", id, verb))
+			}
+		}
+	}
+
+	bulkAction := func(verb string, action func(JobFilter, *http.Request) (uint, error)) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			redirect := r.Referer()
+			if redirect == "" {
+				redirect = "/jobs"
+			}
+
+			filter, err := parseBulkFilter(r)
+			if err != nil {
+				redirectWithMessage(w, r, redirect, "error", err.Error())
+				return
+			}
+
+			affected, err := action(filter, r)
+			if err != nil {
+				redirectWithMessage(w, r, redirect, "error", err.Error())
+			} else {
+				redirectWithMessage(w, r, redirect, "flash", fmt.Sprintf("%d jobs %s", affected, verb))
+			}
+		}
+	}
+
+	router.GET("/assets/dashboard.css", stylesheet)
+	router.GET("/", overview)
+	router.GET("/queues", queues)
+	router.GET("/jobs", jobList("Jobs", nil, false))
+	router.GET("/scheduled", jobList("Scheduled jobs", &enqueued, true))
+	router.GET("/dead", jobList("Dead jobs", &failed, false))
+	router.GET("/jobs/:id", job)
+	router.GET("/workers", workers)
+	router.POST("/jobs/:id/retry", jobAction("retried", func(id uint, _ *http.Request) error {
+		return c.RetryJob(id)
+	}))
+	router.POST("/jobs/:id/run", jobAction("will run now", func(id uint, _ *http.Request) error {
+		return c.RunJobNow(id)
+	}))
+	router.POST("/jobs/:id/delete", jobAction("deleted", func(id uint, _ *http.Request) error {
+		return c.DeleteJob(id)
+	}))
+	router.POST("/jobs/:id/requeue", jobAction("requeued", func(id uint, r *http.Request) error {
+		queueName, err := queueNameParam(r)
+		if err != nil {
+			return err
+		}
+		return c.RequeueJob(id, queueName)
+	}))
+
+	router.POST("/bulk/retry", bulkAction("retried", func(filter JobFilter, _ *http.Request) (uint, error) {
+		return c.RetryJobs(filter)
+	}))
+	router.POST("/bulk/run", bulkAction("will run now", func(filter JobFilter, _ *http.Request) (uint, error) {
+		return c.RunJobsNow(filter)
+	}))
+	router.POST("/bulk/delete", bulkAction("deleted", func(filter JobFilter, _ *http.Request) (uint, error) {
+		return c.DeleteJobs(filter)
+	}))
+	router.POST("/bulk/requeue", bulkAction("requeued", func(filter JobFilter, r *http.Request) (uint, error) {
+		queueName, err := queueNameParam(r)
+		if err != nil {
+			return 0, err
+		}
+		return c.RequeueJobs(filter, queueName)
+	}))
+}
+
+func redirectWithMessage(w http.ResponseWriter, r *http.Request, target string, kind string, message string) {
+	location, err := url.Parse(target)
+	if err != nil {
+		location = &url.URL{Path: "/"}
+	}
+
+	query := location.Query()
+	query.Del("flash")
+	query.Del("error")
+	query.Set(kind, message)
+	location.RawQuery = query.Encode()
+
+	http.Redirect(w, r, location.String(), http.StatusSeeOther)
+}
+
+func (c Connection) recentOutcomes(since time.Time) (uint, uint, error) {
+	if err := c.requireSQL(); err != nil {
+		return 0, 0, err
+	}
+
+	rows, err := c.db.Raw(`
+    SELECT state, COUNT(*) FROM jobs
+    WHERE finished_at >= ?
+    GROUP BY state`, since).Rows()
+
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var finished, failed uint
+	for rows.Next() {
+		var state JobState
+		var count uint
+
+		if err := rows.Scan(&state, &count); err != nil {
+			return 0, 0, err
+		}
+
+		if state == JobFinished {
+			finished = count
+		} else if state == JobFailed {
+			failed = count
+		}
+	}
+
+	return finished, failed, nil
+}
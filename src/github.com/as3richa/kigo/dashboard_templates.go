@@ -0,0 +1,224 @@
+package kigo
+
+// The dashboard's templates and stylesheet are compiled into the binary so
+// that RunWebface has no files to ship alongside it.
+
+const dashboardStylesheet = `
+body { margin: 0; font: 14px/1.4 -apple-system, "Helvetica Neue", Arial, sans-serif; color: #222; background: #f6f6f4; }
+nav { background: #2d3142; padding: 0 1em; }
+nav a { display: inline-block; color: #dfe3ea; padding: 0.8em 0.7em; text-decoration: none; }
+nav a.brand { font-weight: bold; color: #fff; }
+nav a:hover { background: #3d4257; }
+main { padding: 1em 2em; }
+h1 { font-size: 1.5em; font-weight: normal; }
+h2 { font-size: 1.15em; font-weight: normal; margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; background: #fff; }
+th, td { text-align: left; padding: 0.4em 0.6em; border-bottom: 1px solid #e4e4e0; vertical-align: top; }
+th { background: #eceae4; font-weight: 600; }
+td.number, th.number { text-align: right; }
+.stats { display: flex; flex-wrap: wrap; gap: 1em; }
+.stat { background: #fff; padding: 0.8em 1.2em; min-width: 8em; border: 1px solid #e4e4e0; }
+.stat .value { font-size: 1.8em; }
+.stat .label { color: #666; }
+.state-failed, .status-dead { color: #b00020; }
+.state-running, .status-stale { color: #a15c00; }
+.state-finished, .status-healthy { color: #2e7d32; }
+.flash { background: #fff8d6; border: 1px solid #e8d77a; padding: 0.5em 1em; }
+.error { background: #fde8ea; border: 1px solid #f2b8be; padding: 0.5em 1em; }
+form.inline { display: inline; }
+form.filters { margin-bottom: 1em; }
+form.filters input, form.filters select { margin-right: 0.5em; }
+button { cursor: pointer; }
+button.danger { color: #b00020; }
+pre { background: #fff; padding: 0.8em; border: 1px solid #e4e4e0; overflow-x: auto; }
+.pagination { margin-top: 1em; }
+`
+
+const dashboardLayoutTemplate = `
+{{define "layout"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kigo · {{.Title}}</title>
+<link rel="stylesheet" href="/assets/dashboard.css">
+</head>
+<body>
+<nav>
+<a class="brand" href="/">kigo</a>
+<a href="/queues">Queues</a>
+<a href="/jobs">Jobs</a>
+<a href="/scheduled">Scheduled</a>
+<a href="/dead">Dead</a>
+<a href="/workers">Workers</a>
+</nav>
+<main>
+<h1>{{.Title}}</h1>
+{{with .Flash}}<p class="flash">{{.}}</p>{{end}}
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+{{template "content" .}}
+</main>
+</body>
+</html>
+{{end}}
+
+{{define "jobActions"}}{{if canWrite}}
+{{if eq .State.String "failed"}}
+<form class="inline" method="post" action="/jobs/{{.ID}}/retry">{{csrfField}}<button>Retry</button></form>
+{{end}}
+{{if eq .State.String "enqueued"}}
+<form class="inline" method="post" action="/jobs/{{.ID}}/run">{{csrfField}}<button>Run now</button></form>
+{{end}}
+{{if ne .State.String "running"}}
+<form class="inline" method="post" action="/jobs/{{.ID}}/delete">{{csrfField}}<button class="danger">Delete</button></form>
+{{end}}
+{{end}}{{end}}
+
+{{define "bulkActions"}}{{if canWrite}}
+<h2>All matching jobs</h2>
+<p>
+{{range $action, $label := bulkActionLabels}}
+<form class="inline" method="post" action="/bulk/{{$action}}" onsubmit="return confirm('{{$label}} every matching job?')">
+{{csrfField}}
+{{range $name, $values := $}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">{{end}}{{end}}
+{{if not $}}<input type="hidden" name="all" value="true">{{end}}
+{{if eq $action "requeue"}}<input name="to" placeholder="queue" required>{{end}}
+<button{{if eq $action "delete"}} class="danger"{{end}}>{{$label}}</button>
+</form>
+{{end}}
+</p>
+{{end}}{{end}}
+
+{{define "jobTable"}}
+<table>
+<tr><th>ID</th><th>Queue</th><th>Task</th><th>State</th><th>Enqueued</th><th>Start at</th><th>Finished</th><th>Error</th><th></th></tr>
+{{range .Jobs}}
+<tr>
+<td><a href="/jobs/{{.ID}}">{{.ID}}</a></td>
+<td><a href="/jobs?queue={{.QueueName}}">{{.QueueName}}</a></td>
+<td><a href="/jobs?task={{.TaskName}}">{{.TaskName}}</a></td>
+<td class="state-{{.State}}">{{.State}}</td>
+<td>{{formatTime .EnqueuedAt}}</td>
+<td>{{formatTime .StartAt}}</td>
+<td>{{with .FinishedAt}}{{formatTime .}}{{end}}</td>
+<td>{{with .Error}}{{.}}{{end}}</td>
+<td>{{template "jobActions" .}}</td>
+</tr>
+{{else}}
+<tr><td colspan="9">No jobs</td></tr>
+{{end}}
+</table>
+{{with .Next}}<p class="pagination"><a href="{{.}}">Older jobs →</a></p>{{end}}
+{{end}}
+`
+
+var dashboardPageTemplates = map[string]string{
+	"overview": `
+{{define "content"}}
+<div class="stats">
+<div class="stat"><div class="value">{{.Data.Finished}}</div><div class="label">finished in the last hour</div></div>
+<div class="stat"><div class="value">{{.Data.Failed}}</div><div class="label">failed in the last hour</div></div>
+<div class="stat"><div class="value">{{.Data.Enqueued}}</div><div class="label">enqueued</div></div>
+<div class="stat"><div class="value">{{.Data.Running}}</div><div class="label">running</div></div>
+<div class="stat"><div class="value">{{.Data.Scheduled}}</div><div class="label">scheduled</div></div>
+<div class="stat"><div class="value">{{.Data.Dead}}</div><div class="label">dead</div></div>
+</div>
+<h2>Queue depths</h2>
+{{template "queueTable" .Data.Queues}}
+{{end}}
+`,
+
+	"queues": `
+{{define "content"}}
+{{template "queueTable" .Data}}
+{{end}}
+`,
+
+	"jobs": `
+{{define "content"}}
+<form class="filters" method="get" action="{{.Data.Path}}">
+<input name="queue" placeholder="queue" value="{{.Data.Filter.QueueName}}">
+<input name="task" placeholder="task" value="{{.Data.Filter.TaskName}}">
+{{if .Data.ShowStateFilter}}
+<select name="state">
+<option value="">any state</option>
+{{range .Data.States}}<option value="{{.}}"{{if eq . $.Data.SelectedState}} selected{{end}}>{{.}}</option>{{end}}
+</select>
+{{end}}
+<button>Filter</button>
+</form>
+{{template "jobTable" .Data}}
+{{template "bulkActions" .Data.BulkParams}}
+{{end}}
+`,
+
+	"job": `
+{{define "content"}}
+{{$job := .Data}}
+<table>
+<tr><th>Queue</th><td><a href="/jobs?queue={{$job.QueueName}}">{{$job.QueueName}}</a></td></tr>
+<tr><th>Task</th><td><a href="/jobs?task={{$job.TaskName}}">{{$job.TaskName}}</a></td></tr>
+<tr><th>State</th><td class="state-{{$job.State}}">{{$job.State}}</td></tr>
+<tr><th>Parameters</th><td><pre>{{toJSON $job.Parameters}}</pre></td></tr>
+<tr><th>Error</th><td>{{with $job.Error}}<pre>{{.}}</pre>{{end}}</td></tr>
+<tr><th>Enqueued</th><td>{{formatTime $job.EnqueuedAt}}</td></tr>
+<tr><th>Start at</th><td>{{formatTime $job.StartAt}}</td></tr>
+<tr><th>Started</th><td>{{with $job.StartedAt}}{{formatTime .}}{{end}}</td></tr>
+<tr><th>Finished</th><td>{{with $job.FinishedAt}}{{formatTime .}}{{end}}</td></tr>
+<tr><th>Worker</th><td>{{with $job.WorkerID}}<a href="/workers#worker-{{.}}">{{.}}</a> {{$job.WorkerName}}{{end}}</td></tr>
+<tr><th>Progress</th><td>{{with $job.Progress}}{{.Percent}}% {{.Message}} ({{formatTime .ReportedAt}}){{end}}</td></tr>
+</table>
+<p>{{template "jobActions" $job}}</p>
+{{if and canWrite (ne $job.State.String "running")}}
+<form class="inline" method="post" action="/jobs/{{$job.ID}}/requeue">
+{{csrfField}}
+<input name="to" placeholder="queue" required>
+<button>Move to queue</button>
+</form>
+{{end}}
+{{end}}
+`,
+
+	"workers": `
+{{define "content"}}
+<table>
+<tr><th>ID</th><th>Name</th><th>Queues</th><th class="number">Concurrency</th><th>Started</th><th>Heartbeat</th><th>Status</th><th>Jobs</th></tr>
+{{range .Data}}
+<tr id="worker-{{.ID}}">
+<td>{{.ID}}</td>
+<td>{{.Name}}</td>
+<td>{{range $i, $queue := .Queues}}{{if $i}}, {{end}}<a href="/jobs?queue={{$queue}}">{{$queue}}</a>{{end}}</td>
+<td class="number">{{.Concurrency}}</td>
+<td>{{formatTime .StartedAt}}</td>
+<td>{{formatTime .HeartbeatAt}} ({{ago .HeartbeatAt}} ago)</td>
+<td class="status-{{.Status}}">{{.Status}}{{if .Orphaned}}, orphaning jobs{{end}}</td>
+<td>{{range .Jobs}}<a href="/jobs/{{.ID}}">{{.ID}}</a> {{.TaskName}}<br>{{end}}</td>
+</tr>
+{{else}}
+<tr><td colspan="8">No workers</td></tr>
+{{end}}
+</table>
+{{end}}
+`,
+}
+
+const dashboardQueueTableTemplate = `
+{{define "queueTable"}}
+<table>
+<tr><th>Queue</th><th class="number">Enqueued</th><th class="number">Scheduled</th><th class="number">Running</th><th class="number">Failed</th><th class="number">Finished</th><th class="number">Latency</th><th class="number">Live workers</th></tr>
+{{range .}}
+<tr>
+<td><a href="/jobs?queue={{.Name}}">{{.Name}}</a></td>
+<td class="number">{{stateCount .Counts "enqueued"}}</td>
+<td class="number">{{.Scheduled}}</td>
+<td class="number">{{stateCount .Counts "running"}}</td>
+<td class="number">{{stateCount .Counts "failed"}}</td>
+<td class="number">{{stateCount .Counts "finished"}}</td>
+<td class="number">{{duration .Latency}}</td>
+<td class="number">{{.LiveWorkers}}</td>
+</tr>
+{{else}}
+<tr><td colspan="8">No queues</td></tr>
+{{end}}
+</table>
+{{end}}
+`
@@ -0,0 +1,67 @@
+package kigo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardTemplatesRender(t *testing.T) {
+	now := time.Now()
+	failure := "augh"
+	workerID := uint(4)
+
+	job := JobInfo{
+		ID:         12,
+		QueueName:  "alpha",
+		TaskName:   "SyncInvoice",
+		Parameters: []interface{}{"<script>"},
+		State:      JobFailed,
+		Error:      &failure,
+		EnqueuedAt: now,
+		StartAt:    now,
+		StartedAt:  &now,
+		FinishedAt: &now,
+		WorkerID:   &workerID,
+		WorkerName: "worker-4",
+		Progress:   &JobProgress{Percent: 50, ReportedAt: now},
+	}
+
+	queues := []QueueStats{{
+		Name:   "alpha",
+		Counts: map[JobState]uint{JobEnqueued: 3, JobFailed: 1},
+	}}
+
+	pages := []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"overview", overviewData{Dead: 1, Queues: queues}, `href="/jobs?queue=alpha"`},
+		{"queues", queues, `<td class="number">3</td>`},
+		{"jobs", jobListData{Path: "/dead", Jobs: []JobInfo{job}, Next: "/dead?after=12"}, `action="/jobs/12/retry"`},
+		{"job", &job, "&#34;\\u003cscript\\u003e&#34;"},
+		{"workers", []WorkerInfo{{ID: 4, Name: "worker-4", Queues: []string{"alpha"}, Status: WorkerHealthy}}, `id="worker-4"`},
+	}
+
+	for _, page := range pages {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/?flash=done", nil)
+		request = request.WithContext(context.WithValue(request.Context(), webfaceSessionKey{}, webfaceSession{role: WebfaceAdmin}))
+
+		renderDashboard(recorder, request, page.name, "Title", page.data)
+
+		body := recorder.Body.String()
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", page.name, recorder.Code, body)
+			continue
+		}
+
+		if !strings.Contains(body, page.expected) || !strings.Contains(body, `<p class="flash">done</p>`) {
+			t.Errorf("%s: expected %q and a flash message in %s", page.name, page.expected, body)
+		}
+	}
+}
@@ -1,160 +1,126 @@
 package kigo
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"time"
 )
 
 func (c Connection) createWorker(workerName string, queueNames []string, concurrency uint) (uint, error) {
-	queueRecords := make([]queueModel, len(queueNames))
-	for i := 0; i < len(queueNames); i++ {
-		queueRecords[i].Name = queueNames[i]
-	}
-
-	now := time.Now()
-
-	workerRecord := workerModel{
-		Name:        workerName,
-		Queues:      queueRecords,
-		Concurrency: concurrency,
-		StartedAt:   now,
-		HeartbeatAt: now,
-	}
-
-	if err := c.db.Create(&workerRecord).Error; err != nil {
-		return 0, err
-	}
-
-	return workerRecord.ID, nil
+	return c.store.CreateWorker(workerName, queueNames, concurrency)
 }
 
 func (c Connection) beatWorker(id uint) error {
-	return c.db.Model(&workerModel{}).Where("id = ?", id).Update("heartbeat_at", time.Now()).Error
+	return c.store.BeatWorker(id)
 }
 
 func (c Connection) terminateWorker(id uint) error {
-	message := "worker was terminated"
-
-	tx := c.db.Begin()
-
-	err := tx.Model(&jobModel{}).Where("worker_id = ?", id).Update(jobModel{
-		State:    jobFailed,
-		WorkerID: nil,
-		Error:    &message,
-	}).Error
-
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return c.store.TerminateWorker(id)
+}
 
-	err = tx.Model(&workerModel{}).Delete(workerModel{ID: id}).Error
+func (c Connection) pushJobTo(queueName string, taskName string, parameters []interface{}, startAt time.Time) (uint, error) {
+	paramBlob, err := encodeValue(parameters)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return 0, fmt.Errorf("serialization failure: %v", err)
 	}
 
-	err = tx.Commit().Error
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return c.store.PushJob(queueName, taskName, paramBlob, startAt)
+}
 
-	return nil
+// undecodableJob is a claimed job whose parameters couldn't be decoded, and
+// which has therefore been failed. failErr is the error from failing it, if
+// any.
+type undecodableJob struct {
+	job     *Job
+	err     error
+	failErr error
 }
 
-func (c Connection) pushJobTo(queueName string, taskName string, parameters []interface{}, startAt time.Time) (uint, error) {
-	paramBuffer := bytes.Buffer{}
-	encoder := gob.NewEncoder(&paramBuffer)
-	encoder.Encode(parameters)
-
-	jobRecord := jobModel{
-		QueueName:  queueName,
-		Queue:      &queueModel { Name: queueName },
-		TaskName:   taskName,
-		ParamBlob:  paramBuffer.Bytes(),
-		State:      jobEnqueued,
-		EnqueuedAt: time.Now(),
-		StartAt:    startAt,
+// popJobsFrom claims and decodes up to limit jobs for the worker. Jobs which
+// can't be decoded are failed on the spot and returned separately, so that the
+// caller can report them.
+func (c Connection) popJobsFrom(workerID uint, queueNames []string, limit uint) ([]*Job, []undecodableJob, error) {
+	encodedJobs, err := c.store.ClaimJobs(workerID, queueNames, limit)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if err := c.db.Create(&jobRecord).Error; err != nil {
-		return 0, err
+	jobs := make([]*Job, 0, len(encodedJobs))
+	var undecodable []undecodableJob
+
+	for _, encodedJob := range encodedJobs {
+		job, err := encodedJob.Decode()
+		if err != nil {
+			undecodable = append(undecodable, undecodableJob{
+				job:     &Job{ID: encodedJob.ID, QueueName: encodedJob.QueueName, TaskName: encodedJob.TaskName},
+				err:     err,
+				failErr: c.failJob(encodedJob.ID, err),
+			})
+			continue
+		}
+		jobs = append(jobs, job)
 	}
 
-	return jobRecord.ID, nil
+	return jobs, undecodable, nil
 }
 
-func (c Connection) popJobFrom(workerID uint, queueNames []string) (*Job, error) {
-	now := time.Now()
-
-	var id int
-	var jobRecord jobModel
+func decodeJob(jobRecord jobModel) (*Job, error) {
+	var parameters []interface{}
 
-	tx := c.db.Begin()
+	if err := decodeValue(jobRecord.ParamBlob, &parameters); err != nil {
+		return nil, fmt.Errorf("deserialization failure: %v", err)
+	}
 
-	err := tx.Raw(`
-    SELECT id FROM jobs
-    WHERE queue_name IN (?) AND state = ? AND start_at <= ?
-    ORDER BY enqueued_at ASC LIMIT 1 FOR UPDATE`, queueNames, jobEnqueued, now).Row().Scan(&id)
+	return &Job{
+		ID:         jobRecord.ID,
+		QueueName:  jobRecord.QueueName,
+		TaskName:   jobRecord.TaskName,
+		Parameters: parameters,
+	}, nil
+}
 
-	if err != nil && err != sql.ErrNoRows {
-		tx.Rollback()
-		return nil, err
-	} else if err == sql.ErrNoRows {
-		tx.Rollback()
-		return nil, nil
-	}
+func (c Connection) finishJob(id uint, result []byte) error {
+	return c.store.FinishJob(id, result)
+}
 
-	err = tx.Model(&jobRecord).Where("id = ?", id).Update(jobModel{
-		State:     jobRunning,
-		WorkerID:  &workerID,
-		StartedAt: &now}).Error
+func (c Connection) failJob(id uint, jobErr error) error {
+	return c.store.FailJob(id, jobErr.Error())
+}
 
+func (c Connection) fetchJobOutcome(id uint) (JobOutcome, error) {
+	encodedOutcome, err := c.store.FetchJobOutcome(id)
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return JobOutcome{}, err
 	}
 
-	if err = tx.Commit().Error; err != nil {
-		tx.Rollback()
-		return nil, err
+	outcome := JobOutcome{ID: id, State: encodedOutcome.State}
+	if encodedOutcome.Error != nil {
+		outcome.Error = errors.New(*encodedOutcome.Error)
 	}
 
-	if err = c.db.First(&jobRecord, id).Error; err != nil {
-		return nil, err
-	}
-
-	paramBuffer := bytes.NewBuffer(jobRecord.ParamBlob)
-	decoder := gob.NewDecoder(paramBuffer)
-	var parameters []interface{}
+	return outcome, nil
+}
 
-	if err = decoder.Decode(&parameters); err != nil {
-		return nil, fmt.Errorf("deserialization failure: %v", err)
+func (c Connection) fetchJobResult(id uint) (JobState, []byte, error) {
+	encodedOutcome, err := c.store.FetchJobOutcome(id)
+	if err != nil {
+		return 0, nil, err
 	}
+	return encodedOutcome.State, encodedOutcome.ResultBlob, nil
+}
 
-	return &Job{
-		ID:         jobRecord.ID,
-		TaskName:   jobRecord.TaskName,
-		Parameters: parameters,
-	}, nil
+func (c Connection) updateJobProgress(id uint, progress JobProgress) error {
+	return c.store.SaveJobProgress(id, progress)
+}
+
+func (c Connection) fetchJobProgress(id uint) (*JobProgress, error) {
+	return c.store.FetchJobProgress(id)
 }
 
-func (c Connection) finishJob(id uint) error {
-	return c.db.Model(&jobModel{}).Where("id = ?", id).Update(map[string]interface{}{
-		"state":     jobFinished,
-		"worker_id": nil,
-		"error":     nil,
-	}).Error
+func (c Connection) appendJobLogs(id uint, lines []JobLogLine) error {
+	return c.store.AppendJobLogs(id, lines, jobLogLineLimit)
 }
 
-func (c Connection) failJob(id uint, err error) error {
-	return c.db.Model(&jobModel{}).Where("id = ?", id).Update(map[string]interface{}{
-		"state":     jobFailed,
-		"worker_id": nil,
-		"error":     err.Error(),
-	}).Error
+func (c Connection) fetchJobLogs(id uint) ([]JobLogLine, error) {
+	return c.store.FetchJobLogs(id)
 }
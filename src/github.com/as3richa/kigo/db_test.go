@@ -0,0 +1,235 @@
+package kigo
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPopsWorkConcurrentlyAsExpected(t *testing.T) {
+	const queueCount = 20
+	const jobCount = 100
+	const consumersPerQueue = 4
+	const batchSize = 5
+
+	var queueNames [queueCount]string
+	for i := 0; i < queueCount; i++ {
+		queueNames[i] = fmt.Sprintf("%d", i)
+	}
+
+	withConnection(t, func(c Connection) {
+		var w sync.WaitGroup
+		w.Add(queueCount * (1 + consumersPerQueue))
+
+		for _, q := range queueNames {
+			go func(q string) {
+				defer w.Done()
+				for i := 0; i < jobCount; i++ {
+					_, err := c.pushJobTo(q, fmt.Sprintf("%d", i), []interface{}{i}, time.Now())
+					expectSuccess(t, err)
+					time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+				}
+			}(q)
+		}
+
+		mutex := sync.Mutex{}
+		dequeued := map[string]map[int]int{}
+		remaining := map[string]int{}
+		for _, q := range queueNames {
+			dequeued[q] = map[int]int{}
+			remaining[q] = jobCount
+		}
+
+		for _, q := range queueNames {
+			for i := 0; i < consumersPerQueue; i++ {
+				go func(q string) {
+					queues := []string{q}
+					defer w.Done()
+
+					for {
+						mutex.Lock()
+						done := remaining[q] == 0
+						mutex.Unlock()
+						if done {
+							return
+						}
+
+						jobs, _, err := c.popJobsFrom(1, queues, batchSize)
+						expectSuccess(t, err)
+
+						mutex.Lock()
+						for _, job := range jobs {
+							dequeued[q][job.Parameters[0].(int)]++
+							remaining[q]--
+						}
+						mutex.Unlock()
+
+						time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+					}
+				}(q)
+			}
+		}
+
+		w.Wait()
+
+		for _, q := range queueNames {
+			for i := 0; i < jobCount; i++ {
+				if dequeued[q][i] != 1 {
+					t.Fatalf("Job %d on queue %s was popped %d times", i, q, dequeued[q][i])
+				}
+			}
+		}
+	})
+}
+
+func TestPopsOldestJobsFirst(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		workerID, err := c.createWorker("test", []string{"alpha"}, 5)
+		expectSuccess(t, err)
+
+		// Enqueued in the opposite order to their ids, with a tie
+		base := time.Now().Add(-time.Hour)
+		var ids []uint
+		for i := 0; i < 5; i++ {
+			id, err := c.pushJobTo("alpha", "order", []interface{}{i}, time.Now())
+			expectSuccess(t, err)
+
+			enqueuedAt := base.Add(time.Duration(4-i) * time.Minute)
+			if i == 4 {
+				enqueuedAt = base.Add(time.Minute)
+			}
+			expectSuccess(t, c.db.Model(&jobModel{}).Where("id = ?", id).Update("enqueued_at", enqueuedAt).Error)
+			ids = append(ids, id)
+		}
+
+		jobs, _, err := c.popJobsFrom(workerID, []string{"alpha"}, 5)
+		expectSuccess(t, err)
+
+		expected := []uint{ids[3], ids[4], ids[2], ids[1], ids[0]}
+		if len(jobs) != len(expected) {
+			t.Fatalf("expected %d jobs, got %v", len(expected), jobs)
+		}
+		for i, job := range jobs {
+			if job.ID != expected[i] {
+				t.Fatalf("expected jobs %v in order, got job %d at %d", expected, job.ID, i)
+			}
+		}
+	})
+}
+
+func TestPopsPastLockedJobs(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		if dialect := c.dialect(); dialect != "postgres" && dialect != "mysql" {
+			t.Skipf("claiming with SKIP LOCKED needs %s to name a Postgres or MySQL database, not %s", testingURLVariable, dialect)
+		}
+
+		workerID, err := c.createWorker("test", []string{"alpha"}, 1)
+		expectSuccess(t, err)
+
+		locked, err := c.pushJobTo("alpha", "locked", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+		free, err := c.pushJobTo("alpha", "locked", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		// Another claim is in the middle of taking the oldest job
+		tx := c.db.Begin()
+		defer tx.Rollback()
+		expectSuccess(t, c.backend().lockRows(tx).Where("id = ?", locked).Find(&[]jobModel{}).Error)
+
+		popped := make(chan []*Job, 1)
+		go func() {
+			jobs, _, err := c.popJobsFrom(workerID, []string{"alpha"}, 1)
+			expectSuccess(t, err)
+			popped <- jobs
+		}()
+
+		select {
+		case jobs := <-popped:
+			if len(jobs) != 1 || jobs[0].ID != free {
+				t.Errorf("expected job %d to be claimed past the locked job, got %v", free, jobs)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected claiming not to wait for the locked job")
+		}
+	})
+}
+
+func BenchmarkPopJobsConcurrently(b *testing.B) {
+	for _, batchSize := range []uint{1, 10} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			withConnection(b, func(c Connection) {
+				for i := 0; i < b.N*int(batchSize); i++ {
+					_, err := c.pushJobTo("hot", "bench", []interface{}{i}, time.Now())
+					expectSuccess(b, err)
+				}
+
+				queues := []string{"hot"}
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						_, _, err := c.popJobsFrom(1, queues, batchSize)
+						expectSuccess(b, err)
+					}
+				})
+			})
+		})
+	}
+}
+
+func TestAppendJobLogsKeepsTheLastLines(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		id, err := c.pushJobTo("logs", "appendLogs", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		for batch := 0; batch < 3; batch++ {
+			lines := make([]JobLogLine, jobLogLineLimit/2+1)
+			for i := range lines {
+				lines[i] = JobLogLine{Level: "info", Message: fmt.Sprintf("%d.%d", batch, i), LoggedAt: time.Now()}
+			}
+			expectSuccess(t, c.appendJobLogs(id, lines))
+		}
+
+		lines, err := c.JobLogs(id)
+		expectSuccess(t, err)
+
+		last := fmt.Sprintf("2.%d", jobLogLineLimit/2)
+		if len(lines) != jobLogLineLimit || lines[len(lines)-1].Message != last {
+			t.Fatalf("expected the last %d lines ending with %s, got %d lines", jobLogLineLimit, last, len(lines))
+		}
+	})
+}
+
+func TestFinishingJobsWhichStoppedRunning(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		workerID, err := c.createWorker("test", []string{"alpha"}, 1)
+		expectSuccess(t, err)
+
+		id, err := c.pushJobTo("alpha", "stopped", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		if err := c.finishJob(id, nil); err != ErrJobNotRunning {
+			t.Errorf("expected %v finishing an enqueued job, got %v", ErrJobNotRunning, err)
+		}
+
+		jobs, _, err := c.popJobsFrom(workerID, []string{"alpha"}, 1)
+		expectSuccess(t, err)
+		if len(jobs) != 1 {
+			t.Fatalf("expected to claim job %d, got %v", id, jobs)
+		}
+
+		expectSuccess(t, c.terminateWorker(workerID))
+
+		if err := c.finishJob(id, nil); err != ErrJobNotRunning {
+			t.Errorf("expected %v finishing a job whose worker was terminated, got %v", ErrJobNotRunning, err)
+		}
+
+		outcome, err := c.fetchJobOutcome(id)
+		expectSuccess(t, err)
+		if outcome.State != JobFailed {
+			t.Errorf("expected the job to stay failed, got %v", outcome)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package kigo
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultDurationWindows are the sliding windows reported by the webface when
+// none are asked for; comparing them shows whether a task has slowed down
+// recently, e.g. since a deploy.
+var DefaultDurationWindows = []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour}
+
+// Execution times are counted in a histogram of log-spaced bins, eight per
+// doubling, so that percentiles can be estimated to within about 9% without
+// keeping every job's duration. Bin 0 holds durations under a microsecond; bin
+// b holds those under 2^(b/8) microseconds.
+const durationBinsPerDoubling = 8
+
+// DurationStats summarizes the execution times, from StartedAt to FinishedAt,
+// of one task's jobs which finished or failed within a window. The percentiles
+// are estimated from a histogram, and are at most about 9% too high.
+type DurationStats struct {
+	TaskName string
+	Count    uint
+
+	Mean time.Duration
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+type jobDurationKey struct {
+	bucket time.Time
+	task   string
+	bin    uint
+}
+
+type jobDurationCounts struct {
+	completed uint
+	total     time.Duration
+	max       time.Duration
+}
+
+func newJobDurationCounts(duration time.Duration) jobDurationCounts {
+	return jobDurationCounts{completed: 1, total: duration, max: duration}
+}
+
+func (c *jobDurationCounts) add(other jobDurationCounts) {
+	c.completed += other.completed
+	c.total += other.total
+	if other.max > c.max {
+		c.max = other.max
+	}
+}
+
+func durationKey(t time.Time, taskName string, duration time.Duration) jobDurationKey {
+	return jobDurationKey{bucket: t.UTC().Truncate(time.Minute), task: taskName, bin: durationBin(duration)}
+}
+
+func durationBin(duration time.Duration) uint {
+	micros := float64(duration) / float64(time.Microsecond)
+	if micros < 1 {
+		return 0
+	}
+	return 1 + uint(math.Floor(durationBinsPerDoubling*math.Log2(micros)))
+}
+
+// durationBinLimit is the upper bound of a bin's durations.
+func durationBinLimit(bin uint) time.Duration {
+	return time.Duration(math.Exp2(float64(bin)/durationBinsPerDoubling) * float64(time.Microsecond))
+}
+
+// addJobDurations adds counts to a bucket's bin, creating it if necessary, in
+// the same way as addJobStats.
+func (c Connection) addJobDurations(db *gorm.DB, resolution time.Duration, key jobDurationKey, counts jobDurationCounts) error {
+	seconds := uint(resolution / time.Second)
+	maxUs := uint64(counts.max / time.Microsecond)
+
+	_, err := c.backend().insertIgnoringConflicts(db, "job_durations",
+		"resolution, bucket_start, task_name, bin, completed, total_us, max_us",
+		seconds, key.bucket, key.task, key.bin, 0, 0, 0)
+
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&jobDurationModel{}).
+		Where("resolution = ? AND bucket_start = ? AND task_name = ? AND bin = ?", seconds, key.bucket, key.task, key.bin).
+		Updates(map[string]interface{}{
+			"completed": gorm.Expr("completed + ?", counts.completed),
+			"total_us":  gorm.Expr("total_us + ?", uint64(counts.total/time.Microsecond)),
+			"max_us":    gorm.Expr("CASE WHEN max_us < ? THEN ? ELSE max_us END", maxUs, maxUs),
+		}).Error
+}
+
+// compactJobDurations folds old duration buckets into coarser ones, on the same
+// schedule and with the same locking as compactJobStats.
+func (c Connection) compactJobDurations(now time.Time) error {
+	for i := 0; i+1 < len(statResolutions); i++ {
+		from, to := statResolutions[i], statResolutions[i+1]
+		cutoff := now.Add(-from.keep).UTC().Truncate(to.width)
+
+		tx := c.db.Begin()
+		locked := c.backend().lockRows(tx)
+
+		var durationRecords []jobDurationModel
+		err := locked.
+			Where("resolution = ? AND bucket_start < ?", uint(from.width/time.Second), cutoff).
+			Find(&durationRecords).Error
+
+		if err != nil || len(durationRecords) == 0 {
+			tx.Rollback()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		compacted := map[jobDurationKey]*jobDurationCounts{}
+		ids := make([]uint, len(durationRecords))
+
+		for i, durationRecord := range durationRecords {
+			key := jobDurationKey{
+				bucket: durationRecord.BucketStart.UTC().Truncate(to.width),
+				task:   durationRecord.TaskName,
+				bin:    durationRecord.Bin,
+			}
+
+			if _, ok := compacted[key]; !ok {
+				compacted[key] = &jobDurationCounts{}
+			}
+			compacted[key].add(durationRecord.counts())
+			ids[i] = durationRecord.ID
+		}
+
+		for key, counts := range compacted {
+			if err := c.addJobDurations(tx, to.width, key, *counts); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if err := tx.Where("id IN (?)", ids).Delete(&jobDurationModel{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d jobDurationModel) counts() jobDurationCounts {
+	return jobDurationCounts{
+		completed: d.Completed,
+		total:     time.Duration(d.TotalUs) * time.Microsecond,
+		max:       time.Duration(d.MaxUs) * time.Microsecond,
+	}
+}
+
+// DurationStats returns execution time statistics for each task with jobs which
+// completed in the last window, ordered by task name. Durations are recorded
+// by workers on each heartbeat, and are kept as long as the job_stats buckets;
+// windows longer than a day (or 30 days) count whole hours (or days).
+func (c Connection) DurationStats(window time.Duration) ([]DurationStats, error) {
+	if err := c.requireSQL(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Model(&jobDurationModel{}).
+		Select("task_name, bin, SUM(completed), SUM(total_us), MAX(max_us)").
+		Where("bucket_start >= ?", time.Now().Add(-window).UTC().Truncate(time.Minute)).
+		Group("task_name, bin").
+		Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histograms := map[string]map[uint]jobDurationCounts{}
+	for rows.Next() {
+		var taskName string
+		var bin, completed uint
+		var totalUs, maxUs uint64
+
+		if err := rows.Scan(&taskName, &bin, &completed, &totalUs, &maxUs); err != nil {
+			return nil, err
+		}
+
+		if _, ok := histograms[taskName]; !ok {
+			histograms[taskName] = map[uint]jobDurationCounts{}
+		}
+
+		histograms[taskName][bin] = jobDurationModel{Completed: completed, TotalUs: totalUs, MaxUs: maxUs}.counts()
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make([]DurationStats, 0, len(histograms))
+	for taskName, histogram := range histograms {
+		stats = append(stats, summarizeDurations(taskName, histogram))
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].TaskName < stats[j].TaskName
+	})
+
+	return stats, nil
+}
+
+// summarizeDurations computes statistics from a non-empty histogram.
+func summarizeDurations(taskName string, histogram map[uint]jobDurationCounts) DurationStats {
+	bins := make([]uint, 0, len(histogram))
+	var all jobDurationCounts
+
+	for bin, counts := range histogram {
+		bins = append(bins, bin)
+		all.add(counts)
+	}
+
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i] < bins[j]
+	})
+
+	// percentile estimates the nearest-rank percentile as the upper bound of
+	// the bin holding it
+	percentile := func(p uint) time.Duration {
+		rank := (p*all.completed + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+
+		var seen uint
+		for _, bin := range bins {
+			seen += histogram[bin].completed
+			if seen >= rank {
+				if limit := durationBinLimit(bin); limit < all.max {
+					return limit
+				}
+				break
+			}
+		}
+		return all.max
+	}
+
+	return DurationStats{
+		TaskName: taskName,
+		Count:    all.completed,
+		Mean:     all.total / time.Duration(all.completed),
+		P50:      percentile(50),
+		P95:      percentile(95),
+		P99:      percentile(99),
+		Max:      all.max,
+	}
+}
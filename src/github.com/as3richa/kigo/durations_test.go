@@ -0,0 +1,80 @@
+package kigo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarizeDurations(t *testing.T) {
+	histogram := map[uint]jobDurationCounts{}
+	for i := 100; i >= 1; i-- {
+		duration := time.Duration(i) * time.Millisecond
+		counts := histogram[durationBin(duration)]
+		counts.add(newJobDurationCounts(duration))
+		histogram[durationBin(duration)] = counts
+	}
+
+	stats := summarizeDurations("SyncInvoice", histogram)
+
+	if stats.TaskName != "SyncInvoice" || stats.Count != 100 || stats.Mean != 50500*time.Microsecond || stats.Max != 100*time.Millisecond {
+		t.Errorf("expected exact count, mean and max, got %+v", stats)
+	}
+
+	for _, estimate := range []struct {
+		actual   time.Duration
+		estimate time.Duration
+	}{{50 * time.Millisecond, stats.P50}, {95 * time.Millisecond, stats.P95}, {99 * time.Millisecond, stats.P99}} {
+		if estimate.estimate < estimate.actual || estimate.estimate > estimate.actual*110/100 {
+			t.Errorf("expected an estimate within 10%% above %v, got %v", estimate.actual, estimate.estimate)
+		}
+	}
+
+	single := summarizeDurations("Ping", map[uint]jobDurationCounts{durationBin(time.Second): newJobDurationCounts(time.Second)})
+	if single.P50 != time.Second || single.P99 != time.Second || single.Mean != time.Second {
+		t.Errorf("expected every statistic of one job to be its duration, got %+v", single)
+	}
+}
+
+func TestDurationStatsUsesRecordedDurations(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		now := time.Now()
+		recorder := newJobStatsRecorder()
+		recorder.compactedAt = now
+		listener := recorder.listener(7)
+
+		fast := &Job{QueueName: "alpha", TaskName: "Fast"}
+		slow := &Job{QueueName: "alpha", TaskName: "Slow"}
+
+		listener(Event{Kind: JobFinishedEvent, WorkerID: 7, Time: now, Job: fast, Duration: time.Millisecond})
+		listener(Event{Kind: JobFailedEvent, WorkerID: 7, Time: now, Job: slow, Duration: 50 * time.Millisecond})
+		listener(Event{Kind: JobFinishedEvent, WorkerID: 7, Time: now.Add(-48 * time.Hour), Job: slow, Duration: time.Second})
+		expectSuccess(t, recorder.flush(c, now))
+
+		stats, err := c.DurationStats(time.Hour)
+		expectSuccess(t, err)
+
+		if len(stats) != 2 || stats[0].TaskName != "Fast" || stats[1].TaskName != "Slow" {
+			t.Fatalf("expected stats for Fast and Slow, got %+v", stats)
+		}
+
+		if stats[1].Count != 1 || stats[1].Max != 50*time.Millisecond || stats[0].Max >= stats[1].Max {
+			t.Errorf("expected Slow to have taken longer than Fast, got %+v", stats)
+		}
+
+		// Durations outlive the minute buckets by being compacted with them
+		expectSuccess(t, c.compactJobDurations(now))
+
+		var minuteRows int
+		expectSuccess(t, c.db.Model(&jobDurationModel{}).Where("resolution = 60 AND bucket_start < ?", now.Add(-24*time.Hour)).Count(&minuteRows).Error)
+		if minuteRows != 0 {
+			t.Errorf("expected old minute buckets to be compacted, found %d", minuteRows)
+		}
+
+		stats, err = c.DurationStats(72 * time.Hour)
+		expectSuccess(t, err)
+
+		if len(stats) != 2 || stats[1].Count != 2 || stats[1].Max != time.Second {
+			t.Errorf("expected both of Slow's jobs in a 3 day window, got %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package kigo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type EventKind uint
+
+const (
+	JobEnqueuedEvent EventKind = iota
+	JobClaimedEvent
+	JobStartedEvent
+	JobFailedEvent
+	JobFinishedEvent
+	JobCancelledEvent
+	JobRetriedEvent
+	JobDeadEvent
+	WorkerBootedEvent
+	WorkerHeartbeatEvent
+	WorkerStoppedEvent
+)
+
+var eventKindNames = map[EventKind]string{
+	JobEnqueuedEvent:     "job.enqueued",
+	JobClaimedEvent:      "job.claimed",
+	JobStartedEvent:      "job.started",
+	JobFailedEvent:       "job.failed",
+	JobFinishedEvent:     "job.finished",
+	JobCancelledEvent:    "job.cancelled",
+	JobRetriedEvent:      "job.retried",
+	JobDeadEvent:         "job.dead",
+	WorkerBootedEvent:    "worker.booted",
+	WorkerHeartbeatEvent: "worker.heartbeat",
+	WorkerStoppedEvent:   "worker.stopped",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventKind(%d)", uint(k))
+}
+
+// Event describes a single job or worker lifecycle transition. Job is nil for
+// worker events; WorkerID and WorkerName are zero for events emitted outside
+// of a worker (e.g. JobEnqueuedEvent). Error is set for JobFailedEvent and
+// JobDeadEvent, and for WorkerHeartbeatEvent and WorkerStoppedEvent when the
+// heartbeat or the worker failed. Duration is the job's execution time for
+// JobFailedEvent and JobFinishedEvent.
+//
+// Failed jobs aren't retried automatically, so each JobFailedEvent is followed
+// by a JobDeadEvent: the job has been given up on, and stays dead unless it's
+// retried by hand (see RetryJob).
+type Event struct {
+	Kind EventKind
+	Time time.Time
+
+	WorkerID   uint
+	WorkerName string
+
+	Job      *Job
+	Error    error
+	Duration time.Duration
+}
+
+// Listener receives events synchronously on the goroutine that emitted them,
+// so it should return quickly.
+type Listener func(Event)
+
+type EventBus struct {
+	sync.RWMutex
+
+	listeners map[uint]Listener
+	counter   uint
+}
+
+var DefaultEventBus = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{listeners: map[uint]Listener{}}
+}
+
+// Subscribe adds a listener to the bus and returns a function which removes it.
+func (b *EventBus) Subscribe(listener Listener) func() {
+	b.Lock()
+	defer b.Unlock()
+
+	id := b.counter
+	b.counter++
+	b.listeners[id] = listener
+
+	return func() {
+		b.Lock()
+		defer b.Unlock()
+		delete(b.listeners, id)
+	}
+}
+
+func (b *EventBus) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.RLock()
+	listeners := make([]Listener, 0, len(b.listeners))
+	for _, listener := range b.listeners {
+		listeners = append(listeners, listener)
+	}
+	b.RUnlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// Subscribe adds a listener to DefaultEventBus.
+func Subscribe(listener Listener) func() {
+	return DefaultEventBus.Subscribe(listener)
+}
@@ -0,0 +1,135 @@
+package kigo
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEventBusSubscribeAndUnsubscribe(t *testing.T) {
+	bus := NewEventBus()
+
+	var first, second []EventKind
+	unsubscribeFirst := bus.Subscribe(func(event Event) { first = append(first, event.Kind) })
+	bus.Subscribe(func(event Event) { second = append(second, event.Kind) })
+
+	bus.emit(Event{Kind: JobEnqueuedEvent})
+	unsubscribeFirst()
+	bus.emit(Event{Kind: JobFinishedEvent})
+
+	if len(first) != 1 || first[0] != JobEnqueuedEvent {
+		t.Errorf("expected first listener to see only %v, got %v", JobEnqueuedEvent, first)
+	}
+
+	if len(second) != 2 || second[1] != JobFinishedEvent {
+		t.Errorf("expected second listener to see both events, got %v", second)
+	}
+}
+
+func TestWorkerHooksAreWiredAsListeners(t *testing.T) {
+	var running, done []uint
+	var failed []error
+
+	options := &WorkerOptions{
+		JobRunningHook: func(job *Job) { running = append(running, job.ID) },
+		JobFailedHook:  func(job *Job, err error) { failed = append(failed, err) },
+		JobDoneHook:    func(job *Job) { done = append(done, job.ID) },
+	}
+
+	w := &worker{id: 7, events: NewEventBus()}
+	w.events.Subscribe(w.hookListener(options))
+
+	failure := errors.New("augh")
+	w.emit(Event{Kind: JobStartedEvent, Job: &Job{ID: 1}})
+	w.emit(Event{Kind: JobFailedEvent, Job: &Job{ID: 1}, Error: failure})
+	w.emit(Event{Kind: JobFinishedEvent, Job: &Job{ID: 2}})
+	w.events.emit(Event{Kind: JobFinishedEvent, WorkerID: 8, Job: &Job{ID: 3}})
+
+	if len(running) != 1 || running[0] != 1 {
+		t.Errorf("expected running hook for job 1, got %v", running)
+	}
+
+	if len(failed) != 1 || failed[0] != failure {
+		t.Errorf("expected failed hook with %v, got %v", failure, failed)
+	}
+
+	if len(done) != 1 || done[0] != 2 {
+		t.Errorf("expected done hook for job 2 only, got %v", done)
+	}
+}
+
+func TestUndecodableJobsEmitFailures(t *testing.T) {
+	c, err := ConnectMemory(MemoryOptions{})
+	expectSuccess(t, err)
+
+	id, err := c.store.PushJob("undecodable", "undecodable", []byte("garbage"), time.Now())
+	expectSuccess(t, err)
+
+	failed := make(chan uint, 1)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	terminator := make(chan struct{})
+	stopped := make(chan error)
+
+	go func() {
+		stopped <- c.RunWorkerWithOptions([]string{"undecodable"}, 1, &WorkerOptions{
+			Logger:        logger,
+			Terminator:    terminator,
+			EventBus:      NewEventBus(),
+			JobFailedHook: func(job *Job, err error) { failed <- job.ID },
+		})
+	}()
+
+	select {
+	case failedID := <-failed:
+		if failedID != id {
+			t.Errorf("expected job %d to fail, got %d", id, failedID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the undecodable job's failure to reach the hook")
+	}
+
+	close(terminator)
+	expectSuccess(t, <-stopped)
+
+	outcome, err := c.fetchJobOutcome(id)
+	expectSuccess(t, err)
+	if outcome.State != JobFailed {
+		t.Errorf("expected the job to have failed, got %v", outcome)
+	}
+}
+
+func TestConnectionEventBus(t *testing.T) {
+	c, err := ConnectMemory(MemoryOptions{})
+	expectSuccess(t, err)
+	defer c.Close()
+
+	bus := NewEventBus()
+	c = c.WithEventBus(bus)
+
+	var kinds []EventKind
+	bus.Subscribe(func(event Event) { kinds = append(kinds, event.Kind) })
+
+	var leaked bool
+	unsubscribe := Subscribe(func(Event) { leaked = true })
+	defer unsubscribe()
+
+	id, err := c.PerformTask("eventBus", []interface{}{})
+	expectSuccess(t, err)
+	expectSuccess(t, c.CancelJob(id))
+
+	expected := []EventKind{JobEnqueuedEvent, JobCancelledEvent, JobFailedEvent, JobDeadEvent}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("expected events %v on the connection's bus, got %v", expected, kinds)
+	}
+
+	if leaked {
+		t.Error("expected nothing to be emitted on the default bus")
+	}
+}
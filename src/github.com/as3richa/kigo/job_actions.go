@@ -0,0 +1,288 @@
+package kigo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrJobNotFailed = errors.New("job has not failed")
+var ErrJobNotEnqueued = errors.New("job is not enqueued")
+var ErrJobRunning = errors.New("job is running")
+var ErrJobNotRunning = errors.New("job is no longer running")
+
+const jobActionBatchSize = 500
+
+const cancelledJobMessage = "job was cancelled"
+
+// RetryJob puts a failed job back on its queue to run immediately.
+func (c Connection) RetryJob(id uint) error {
+	job, err := c.FindJob(id)
+	if err != nil {
+		return err
+	}
+
+	query := c.db.Model(&jobModel{}).Where("id = ? AND state = ?", id, JobFailed).Update(retryUpdates(time.Now()))
+	if err := checkJobAction(query, ErrJobNotFailed); err != nil {
+		return err
+	}
+
+	c.recordRetries([]jobModel{{QueueName: job.QueueName, TaskName: job.TaskName}})
+
+	c.eventBus().emit(Event{Kind: JobRetriedEvent, Job: &Job{
+		ID:         job.ID,
+		QueueName:  job.QueueName,
+		TaskName:   job.TaskName,
+		Parameters: job.Parameters,
+	}})
+
+	return nil
+}
+
+// DeleteJob deletes a job which isn't running, along with its logs.
+func (c Connection) DeleteJob(id uint) error {
+	if _, err := c.FindJob(id); err != nil {
+		return err
+	}
+
+	query := c.db.Where("id = ? AND state <> ?", id, JobRunning).Delete(&jobModel{})
+	return checkJobAction(query, ErrJobRunning)
+}
+
+// RunJobNow moves an enqueued job's start time to now, so that a job scheduled
+// for later becomes runnable immediately.
+func (c Connection) RunJobNow(id uint) error {
+	if _, err := c.FindJob(id); err != nil {
+		return err
+	}
+
+	query := c.db.Model(&jobModel{}).Where("id = ? AND state = ?", id, JobEnqueued).Update("start_at", time.Now())
+	return checkJobAction(query, ErrJobNotEnqueued)
+}
+
+// CancelJob fails an enqueued job, so that it never runs. Running jobs can only
+// be cancelled through their worker's API.
+func (c Connection) CancelJob(id uint) error {
+	job, err := c.store.CancelJob(id)
+	if err != nil {
+		return err
+	}
+
+	c.reportCancellations([]EncodedJob{job})
+	return nil
+}
+
+// RequeueJob moves a job which isn't running onto another queue, to run
+// immediately.
+func (c Connection) RequeueJob(id uint, queueName string) error {
+	if _, err := c.FindJob(id); err != nil {
+		return err
+	}
+
+	if err := c.ensureQueue(queueName); err != nil {
+		return err
+	}
+
+	query := c.db.Model(&jobModel{}).Where("id = ? AND state <> ?", id, JobRunning).Update(requeueUpdates(queueName, time.Now()))
+	return checkJobAction(query, ErrJobRunning)
+}
+
+// RetryJobs retries every failed job matching the filter, returning the number
+// of jobs retried. Like the other bulk actions, it works through the matching
+// jobs in batches of jobActionBatchSize, so that no single statement holds
+// locks on a large part of the jobs table.
+func (c Connection) RetryJobs(filter JobFilter) (uint, error) {
+	failed := func(query *gorm.DB) *gorm.DB {
+		return query.Where("state = ?", JobFailed)
+	}
+
+	return c.inJobBatches(filter, failed, func(_ *gorm.DB, batch []jobModel) (uint, error) {
+		retried, err := updateJobsIn(c.db, c.backend().lockRows, jobIDs(batch), JobFailed, retryUpdates(time.Now()))
+		if err != nil {
+			return 0, err
+		}
+
+		c.recordRetries(retried)
+		for _, jobRecord := range retried {
+			if job, err := decodeJob(jobRecord); err == nil {
+				c.eventBus().emit(Event{Kind: JobRetriedEvent, Job: job})
+			}
+		}
+		return uint(len(retried)), nil
+	})
+}
+
+// DeleteJobs deletes every job matching the filter which isn't running,
+// returning the number of jobs deleted.
+func (c Connection) DeleteJobs(filter JobFilter) (uint, error) {
+	return c.inJobBatches(filter, notRunning, func(query *gorm.DB, _ []jobModel) (uint, error) {
+		return rowsAffected(query.Delete(&jobModel{}))
+	})
+}
+
+// RequeueJobs moves every job matching the filter which isn't running onto the
+// given queue, to run immediately. It returns the number of jobs moved.
+func (c Connection) RequeueJobs(filter JobFilter, queueName string) (uint, error) {
+	if err := c.ensureQueue(queueName); err != nil {
+		return 0, err
+	}
+
+	return c.inJobBatches(filter, notRunning, func(query *gorm.DB, _ []jobModel) (uint, error) {
+		return rowsAffected(query.Update(requeueUpdates(queueName, time.Now())))
+	})
+}
+
+// RunJobsNow makes every scheduled job matching the filter runnable
+// immediately, returning the number of jobs rescheduled.
+func (c Connection) RunJobsNow(filter JobFilter) (uint, error) {
+	now := time.Now()
+
+	scheduled := func(query *gorm.DB) *gorm.DB {
+		return query.Where("state = ? AND start_at > ?", JobEnqueued, now)
+	}
+
+	return c.inJobBatches(filter, scheduled, func(query *gorm.DB, _ []jobModel) (uint, error) {
+		return rowsAffected(query.Update("start_at", now))
+	})
+}
+
+// CancelJobs fails every enqueued job matching the filter, returning the number
+// of jobs cancelled.
+func (c Connection) CancelJobs(filter JobFilter) (uint, error) {
+	enqueued := func(query *gorm.DB) *gorm.DB {
+		return query.Where("state = ?", JobEnqueued)
+	}
+
+	return c.inJobBatches(filter, enqueued, func(_ *gorm.DB, batch []jobModel) (uint, error) {
+		cancelled, err := c.backend().cancelJobs(jobIDs(batch))
+		if err != nil {
+			return 0, err
+		}
+
+		c.reportCancellations(encodeJobs(cancelled))
+		return uint(len(cancelled)), nil
+	})
+}
+
+// reportCancellations counts cancelled jobs as failed in the job stats, and
+// reports them as a worker reports jobs it cancels.
+func (c Connection) reportCancellations(jobs []EncodedJob) {
+	if c.db != nil {
+		c.recordCancellations(jobs)
+	}
+
+	for _, encodedJob := range jobs {
+		job, err := encodedJob.Decode()
+		if err != nil {
+			job = &Job{ID: encodedJob.ID, QueueName: encodedJob.QueueName, TaskName: encodedJob.TaskName}
+		}
+
+		err = errors.New(cancelledJobMessage)
+		c.eventBus().emit(Event{Kind: JobCancelledEvent, Job: job})
+		c.eventBus().emit(Event{Kind: JobFailedEvent, Job: job, Error: err})
+		c.eventBus().emit(Event{Kind: JobDeadEvent, Job: job, Error: err})
+	}
+}
+
+// ensureQueue creates the named queue if it doesn't exist yet, since jobs'
+// queue names must refer to one.
+func (c Connection) ensureQueue(queueName string) error {
+	if err := c.requireSQL(); err != nil {
+		return err
+	}
+
+	return c.db.FirstOrCreate(&queueModel{}, queueModel{Name: queueName}).Error
+}
+
+func notRunning(query *gorm.DB) *gorm.DB {
+	return query.Where("state <> ?", JobRunning)
+}
+
+// inJobBatches walks the jobs matching both the filter and scope, newest first
+// and at most jobActionBatchSize at a time, and calls apply with a query
+// restricted to each batch; apply returns the number of jobs it acted on.
+// Jobs are paged by ID rather than re-queried from the start, so that actions
+// which leave jobs matching (e.g. requeueing onto the same queue) still
+// terminate.
+func (c Connection) inJobBatches(filter JobFilter, scope func(*gorm.DB) *gorm.DB, apply func(*gorm.DB, []jobModel) (uint, error)) (uint, error) {
+	if err := c.requireSQL(); err != nil {
+		return 0, err
+	}
+
+	var total uint
+	var cursor uint
+
+	for {
+		query := scope(filter.apply(c.db.Model(&jobModel{})))
+		if cursor != 0 {
+			query = query.Where("id < ?", cursor)
+		}
+
+		var batch []jobModel
+		if err := query.Order("id DESC").Limit(jobActionBatchSize).Find(&batch).Error; err != nil {
+			return total, err
+		}
+
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		ids := jobIDs(batch)
+
+		count, err := apply(scope(c.db.Model(&jobModel{}).Where("id IN (?)", ids)), batch)
+		total += count
+		if err != nil {
+			return total, err
+		}
+
+		cursor = ids[len(ids)-1]
+
+		if len(batch) < jobActionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func retryUpdates(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"state":            JobEnqueued,
+		"start_at":         now,
+		"worker_id":        nil,
+		"error":            nil,
+		"result_blob":      nil,
+		"started_at":       nil,
+		"finished_at":      nil,
+		"progress_percent": nil,
+		"progress_message": nil,
+		"progress_at":      nil,
+	}
+}
+
+func requeueUpdates(queueName string, now time.Time) map[string]interface{} {
+	updates := retryUpdates(now)
+	updates["queue_name"] = queueName
+	return updates
+}
+
+func cancelUpdates(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"state":       JobFailed,
+		"error":       cancelledJobMessage,
+		"finished_at": now,
+	}
+}
+
+func rowsAffected(query *gorm.DB) (uint, error) {
+	return uint(query.RowsAffected), query.Error
+}
+
+func checkJobAction(query *gorm.DB, wrongState error) error {
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return wrongState
+	}
+	return nil
+}
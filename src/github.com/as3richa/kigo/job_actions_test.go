@@ -0,0 +1,191 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestJobActions(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		failedID, err := c.pushJobTo("alpha", "act", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+		markRunning(t, c, failedID)
+		expectSuccess(t, c.failJob(failedID, errors.New("augh")))
+
+		scheduledID, err := c.pushJobTo("alpha", "act", []interface{}{}, time.Now().Add(time.Hour))
+		expectSuccess(t, err)
+
+		if err := c.RetryJob(scheduledID); err != ErrJobNotFailed {
+			t.Errorf("expected ErrJobNotFailed, got %v", err)
+		}
+
+		expectSuccess(t, c.RetryJob(failedID))
+		info, err := c.FindJob(failedID)
+		expectSuccess(t, err)
+		if info.State != JobEnqueued || info.Error != nil || info.FinishedAt != nil {
+			t.Errorf("expected retried job to be enqueued afresh, got %+v", info)
+		}
+
+		expectSuccess(t, c.RunJobNow(scheduledID))
+		info, err = c.FindJob(scheduledID)
+		expectSuccess(t, err)
+		if info.StartAt.After(time.Now()) {
+			t.Errorf("expected job to be runnable now, got start at %v", info.StartAt)
+		}
+
+		expectSuccess(t, c.DeleteJob(scheduledID))
+		if _, err := c.FindJob(scheduledID); err == nil {
+			t.Error("expected deleted job to be gone")
+		}
+	})
+}
+
+func TestBulkJobActions(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		var failedIDs []uint
+		for i := 0; i < 5; i++ {
+			id, err := c.pushJobTo("alpha", "SyncInvoice", []interface{}{i}, time.Now())
+			expectSuccess(t, err)
+			markRunning(t, c, id)
+			expectSuccess(t, c.failJob(id, errors.New("augh")))
+			failedIDs = append(failedIDs, id)
+		}
+
+		otherID, err := c.pushJobTo("alpha", "Other", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+		markRunning(t, c, otherID)
+		expectSuccess(t, c.failJob(otherID, errors.New("augh")))
+
+		var retried []uint
+		unsubscribe := Subscribe(func(event Event) {
+			if event.Kind == JobRetriedEvent {
+				retried = append(retried, event.Job.ID)
+			}
+		})
+		defer unsubscribe()
+
+		failed := JobFailed
+		count, err := c.RetryJobs(JobFilter{TaskName: "SyncInvoice", State: &failed, FinishedAfter: time.Now().Add(-time.Hour)})
+		expectSuccess(t, err)
+		if count != 5 || len(retried) != 5 {
+			t.Errorf("expected 5 jobs retried, got %d (%d events)", count, len(retried))
+		}
+
+		count, err = c.RequeueJobs(JobFilter{QueueName: "alpha", TaskName: "SyncInvoice"}, "beta")
+		expectSuccess(t, err)
+		if count != 5 {
+			t.Errorf("expected 5 jobs requeued, got %d", count)
+		}
+
+		info, err := c.FindJob(failedIDs[2])
+		expectSuccess(t, err)
+		if info.QueueName != "beta" || info.State != JobEnqueued {
+			t.Errorf("expected job to be enqueued on beta, got %+v", info)
+		}
+
+		count, err = c.DeleteJobs(JobFilter{QueueName: "beta"})
+		expectSuccess(t, err)
+		if count != 5 {
+			t.Errorf("expected 5 jobs deleted, got %d", count)
+		}
+
+		info, err = c.FindJob(otherID)
+		expectSuccess(t, err)
+		if info.State != JobFailed {
+			t.Errorf("expected unmatched job to be left alone, got %+v", info)
+		}
+	})
+}
+
+func TestCancelJobs(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		scheduledID, err := c.pushJobTo("alpha", "cancel", []interface{}{}, time.Now().Add(time.Hour))
+		expectSuccess(t, err)
+
+		var cancelled, failed, dead []uint
+		unsubscribe := Subscribe(func(event Event) {
+			switch event.Kind {
+			case JobCancelledEvent:
+				cancelled = append(cancelled, event.Job.ID)
+			case JobFailedEvent:
+				failed = append(failed, event.Job.ID)
+			case JobDeadEvent:
+				dead = append(dead, event.Job.ID)
+			}
+		})
+		defer unsubscribe()
+
+		expectSuccess(t, c.CancelJob(scheduledID))
+		info, err := c.FindJob(scheduledID)
+		expectSuccess(t, err)
+		if info.State != JobFailed || info.Error == nil || info.FinishedAt == nil {
+			t.Errorf("expected cancelled job to have failed, got %+v", info)
+		}
+
+		if err := c.CancelJob(scheduledID); err != ErrJobNotEnqueued {
+			t.Errorf("expected ErrJobNotEnqueued, got %v", err)
+		}
+
+		if err := c.CancelJob(scheduledID + 1000); err != gorm.ErrRecordNotFound {
+			t.Errorf("expected %v for a missing job, got %v", gorm.ErrRecordNotFound, err)
+		}
+
+		for i := 0; i < 3; i++ {
+			_, err := c.pushJobTo("beta", "cancel", []interface{}{i}, time.Now())
+			expectSuccess(t, err)
+		}
+		keptID, err := c.pushJobTo("gamma", "cancel", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		count, err := c.CancelJobs(JobFilter{QueueName: "beta"})
+		expectSuccess(t, err)
+		if count != 3 {
+			t.Errorf("expected 3 jobs cancelled, got %d", count)
+		}
+
+		info, err = c.FindJob(keptID)
+		expectSuccess(t, err)
+		if info.State != JobEnqueued {
+			t.Errorf("expected job %d on another queue to stay enqueued, got %v", keptID, info.State)
+		}
+
+		if len(cancelled) != 4 || len(failed) != 4 || len(dead) != 4 {
+			t.Errorf("expected 4 jobs reported cancelled, failed and dead, got %v, %v and %v", cancelled, failed, dead)
+		}
+
+		series, err := c.JobStats(JobStatsQuery{TaskName: "cancel", Resolution: time.Minute})
+		expectSuccess(t, err)
+
+		var failedCount uint
+		for _, s := range series {
+			for _, point := range s.Points {
+				failedCount += point.Failed
+			}
+		}
+		if failedCount != 4 {
+			t.Errorf("expected the cancelled jobs to be counted as failed, got %d", failedCount)
+		}
+	})
+}
+
+func TestBatchActionsOnlyReportJobsTheyUpdated(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		failedID, err := c.pushJobTo("alpha", "batch", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+		markRunning(t, c, failedID)
+		expectSuccess(t, c.failJob(failedID, errors.New("augh")))
+
+		// Selected for the batch, but retried by someone else since
+		retriedID, err := c.pushJobTo("alpha", "batch", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		updated, err := updateJobsIn(c.db, c.backend().lockRows, []uint{failedID, retriedID}, JobFailed, retryUpdates(time.Now()))
+		expectSuccess(t, err)
+		if len(updated) != 1 || updated[0].ID != failedID {
+			t.Errorf("expected only job %d to be retried, got %v", failedID, updated)
+		}
+	})
+}
@@ -0,0 +1,408 @@
+package kigo
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Throughput counters are recorded per minute, and compacted into hourly and
+// then daily buckets as they age, so that the job_stats table stays small.
+var statResolutions = []struct {
+	width time.Duration
+	keep  time.Duration
+}{
+	{time.Minute, 24 * time.Hour},
+	{time.Hour, 30 * 24 * time.Hour},
+	{24 * time.Hour, 0},
+}
+
+const statsCompactionInterval = time.Hour
+const maxStatsPoints = 1440
+
+type JobStatsQuery struct {
+	QueueName string
+	TaskName  string
+
+	// From and To bound the series; they default to an hour ago and now.
+	From time.Time
+	To   time.Time
+
+	// Resolution is the width of each point. It defaults to the finest of a
+	// minute, an hour or a day which gives at most 1440 points. Data older
+	// than a day (or 30 days) has been compacted to hourly (or daily) buckets,
+	// so points at a finer resolution than that are attributed to the start of
+	// the hour (or day).
+	Resolution time.Duration
+}
+
+type JobStatsPoint struct {
+	Time      time.Time `json:"time"`
+	Processed uint      `json:"processed"`
+	Failed    uint      `json:"failed"`
+	Retried   uint      `json:"retried"`
+}
+
+// JobStatsSeries holds the counts of jobs for one queue and task, oldest first.
+// Buckets in which nothing happened are omitted.
+type JobStatsSeries struct {
+	QueueName string          `json:"queueName"`
+	TaskName  string          `json:"taskName"`
+	Points    []JobStatsPoint `json:"points"`
+}
+
+type jobStatKey struct {
+	bucket time.Time
+	queue  string
+	task   string
+}
+
+type jobStatCounts struct {
+	processed uint
+	failed    uint
+	retried   uint
+}
+
+func (c *jobStatCounts) add(other jobStatCounts) {
+	c.processed += other.processed
+	c.failed += other.failed
+	c.retried += other.retried
+}
+
+// jobStatsRecorder accumulates per-minute counts and durations from a worker's
+// events until they're flushed to the database on the next heartbeat.
+type jobStatsRecorder struct {
+	sync.Mutex
+
+	pending     map[jobStatKey]*jobStatCounts
+	durations   map[jobDurationKey]*jobDurationCounts
+	compactedAt time.Time
+}
+
+func newJobStatsRecorder() *jobStatsRecorder {
+	return &jobStatsRecorder{
+		pending:   map[jobStatKey]*jobStatCounts{},
+		durations: map[jobDurationKey]*jobDurationCounts{},
+	}
+}
+
+func (r *jobStatsRecorder) listener(workerID uint) Listener {
+	return func(event Event) {
+		if event.WorkerID != workerID || (event.Kind != JobFinishedEvent && event.Kind != JobFailedEvent) {
+			return
+		}
+
+		var counts jobStatCounts
+		if event.Kind == JobFinishedEvent {
+			counts.processed = 1
+		} else {
+			counts.failed = 1
+		}
+
+		r.Lock()
+		defer r.Unlock()
+		r.add(statKey(event.Time, event.Job.QueueName, event.Job.TaskName), counts)
+		r.addDuration(durationKey(event.Time, event.Job.TaskName, event.Duration), newJobDurationCounts(event.Duration))
+	}
+}
+
+func (r *jobStatsRecorder) add(key jobStatKey, counts jobStatCounts) {
+	if _, ok := r.pending[key]; !ok {
+		r.pending[key] = &jobStatCounts{}
+	}
+	r.pending[key].add(counts)
+}
+
+func (r *jobStatsRecorder) addDuration(key jobDurationKey, counts jobDurationCounts) {
+	if _, ok := r.durations[key]; !ok {
+		r.durations[key] = &jobDurationCounts{}
+	}
+	r.durations[key].add(counts)
+}
+
+// flush writes the pending counts and durations to the database, and compacts
+// old buckets if it hasn't done so for statsCompactionInterval. Whatever
+// couldn't be written is kept for the next flush; without a database, it's
+// dropped.
+func (r *jobStatsRecorder) flush(c Connection, now time.Time) error {
+	r.Lock()
+	pending, durations := r.pending, r.durations
+	r.pending = map[jobStatKey]*jobStatCounts{}
+	r.durations = map[jobDurationKey]*jobDurationCounts{}
+	compact := now.Sub(r.compactedAt) >= statsCompactionInterval
+	r.Unlock()
+
+	// Statistics are only kept in SQL databases
+	if c.db == nil {
+		return nil
+	}
+
+	err := r.flushCounts(c, pending)
+	if err == nil {
+		err = r.flushDurations(c, durations)
+	} else {
+		r.Lock()
+		for key, counts := range durations {
+			r.addDuration(key, *counts)
+		}
+		r.Unlock()
+	}
+
+	if err != nil || !compact {
+		return err
+	}
+
+	if err := c.compactJobStats(now); err != nil {
+		return err
+	}
+
+	if err := c.compactJobDurations(now); err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.compactedAt = now
+	r.Unlock()
+
+	return nil
+}
+
+func (r *jobStatsRecorder) flushCounts(c Connection, pending map[jobStatKey]*jobStatCounts) error {
+	for key, counts := range pending {
+		if err := c.addJobStats(c.db, time.Minute, key, *counts); err != nil {
+			r.Lock()
+			for key, counts := range pending {
+				r.add(key, *counts)
+			}
+			r.Unlock()
+			return err
+		}
+		delete(pending, key)
+	}
+	return nil
+}
+
+func (r *jobStatsRecorder) flushDurations(c Connection, durations map[jobDurationKey]*jobDurationCounts) error {
+	for key, counts := range durations {
+		if err := c.addJobDurations(c.db, time.Minute, key, *counts); err != nil {
+			r.Lock()
+			for key, counts := range durations {
+				r.addDuration(key, *counts)
+			}
+			r.Unlock()
+			return err
+		}
+		delete(durations, key)
+	}
+	return nil
+}
+
+func statKey(t time.Time, queueName string, taskName string) jobStatKey {
+	return jobStatKey{bucket: t.UTC().Truncate(time.Minute), queue: queueName, task: taskName}
+}
+
+// addJobStats adds counts to a bucket, creating it if necessary. It's written
+// as an insert followed by an increment, rather than an upsert, so that it
+// works on every supported database.
+func (c Connection) addJobStats(db *gorm.DB, resolution time.Duration, key jobStatKey, counts jobStatCounts) error {
+	seconds := uint(resolution / time.Second)
+
+	_, err := c.backend().insertIgnoringConflicts(db, "job_stats",
+		"resolution, bucket_start, queue_name, task_name, processed, failed, retried",
+		seconds, key.bucket, key.queue, key.task, 0, 0, 0)
+
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&jobStatModel{}).
+		Where("resolution = ? AND bucket_start = ? AND queue_name = ? AND task_name = ?", seconds, key.bucket, key.queue, key.task).
+		Updates(map[string]interface{}{
+			"processed": gorm.Expr("processed + ?", counts.processed),
+			"failed":    gorm.Expr("failed + ?", counts.failed),
+			"retried":   gorm.Expr("retried + ?", counts.retried),
+		}).Error
+}
+
+// recordRetries counts retried jobs in the current minute's buckets. Failing to
+// do so doesn't fail the retry.
+func (c Connection) recordRetries(jobRecords []jobModel) {
+	now := time.Now()
+
+	retries := map[jobStatKey]uint{}
+	for _, jobRecord := range jobRecords {
+		retries[statKey(now, jobRecord.QueueName, jobRecord.TaskName)]++
+	}
+
+	for key, count := range retries {
+		_ = c.addJobStats(c.db, time.Minute, key, jobStatCounts{retried: count})
+	}
+}
+
+// recordCancellations counts jobs cancelled before they ran as failed.
+func (c Connection) recordCancellations(jobs []EncodedJob) {
+	now := time.Now()
+
+	cancellations := map[jobStatKey]uint{}
+	for _, job := range jobs {
+		cancellations[statKey(now, job.QueueName, job.TaskName)]++
+	}
+
+	for key, count := range cancellations {
+		_ = c.addJobStats(c.db, time.Minute, key, jobStatCounts{failed: count})
+	}
+}
+
+// compactJobStats folds buckets older than each resolution's retention period
+// into buckets of the next coarser resolution. The rows being folded are
+// locked, so that concurrent compactions by several workers don't count them
+// twice.
+func (c Connection) compactJobStats(now time.Time) error {
+	for i := 0; i+1 < len(statResolutions); i++ {
+		from, to := statResolutions[i], statResolutions[i+1]
+		cutoff := now.Add(-from.keep).UTC().Truncate(to.width)
+
+		tx := c.db.Begin()
+		locked := c.backend().lockRows(tx)
+
+		var statRecords []jobStatModel
+		err := locked.
+			Where("resolution = ? AND bucket_start < ?", uint(from.width/time.Second), cutoff).
+			Find(&statRecords).Error
+
+		if err != nil || len(statRecords) == 0 {
+			tx.Rollback()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		compacted := map[jobStatKey]*jobStatCounts{}
+		ids := make([]uint, len(statRecords))
+
+		for i, statRecord := range statRecords {
+			key := jobStatKey{
+				bucket: statRecord.BucketStart.UTC().Truncate(to.width),
+				queue:  statRecord.QueueName,
+				task:   statRecord.TaskName,
+			}
+
+			if _, ok := compacted[key]; !ok {
+				compacted[key] = &jobStatCounts{}
+			}
+			compacted[key].add(statRecord.counts())
+			ids[i] = statRecord.ID
+		}
+
+		for key, counts := range compacted {
+			if err := c.addJobStats(tx, to.width, key, *counts); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if err := tx.Where("id IN (?)", ids).Delete(&jobStatModel{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s jobStatModel) counts() jobStatCounts {
+	return jobStatCounts{processed: s.Processed, failed: s.Failed, retried: s.Retried}
+}
+
+// JobStats returns the counts of processed, failed and retried jobs over time,
+// with one series per queue and task matching the query.
+func (c Connection) JobStats(query JobStatsQuery) ([]JobStatsSeries, error) {
+	if err := c.requireSQL(); err != nil {
+		return nil, err
+	}
+
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+
+	if query.From.IsZero() {
+		query.From = query.To.Add(-time.Hour)
+	}
+
+	if query.Resolution <= 0 {
+		for _, resolution := range statResolutions {
+			query.Resolution = resolution.width
+			if query.To.Sub(query.From)/resolution.width <= maxStatsPoints {
+				break
+			}
+		}
+	}
+
+	scope := c.db.Where("bucket_start >= ? AND bucket_start < ?", query.From.UTC().Truncate(query.Resolution), query.To.UTC())
+	if query.QueueName != "" {
+		scope = scope.Where("queue_name = ?", query.QueueName)
+	}
+	if query.TaskName != "" {
+		scope = scope.Where("task_name = ?", query.TaskName)
+	}
+
+	var statRecords []jobStatModel
+	if err := scope.Find(&statRecords).Error; err != nil {
+		return nil, err
+	}
+
+	buckets := map[jobStatKey]*jobStatCounts{}
+	for _, statRecord := range statRecords {
+		key := statKey(statRecord.BucketStart, statRecord.QueueName, statRecord.TaskName)
+		key.bucket = key.bucket.Truncate(query.Resolution)
+
+		if _, ok := buckets[key]; !ok {
+			buckets[key] = &jobStatCounts{}
+		}
+		buckets[key].add(statRecord.counts())
+	}
+
+	type seriesKey struct {
+		queue string
+		task  string
+	}
+
+	seriesByKey := map[seriesKey]*JobStatsSeries{}
+	for key, counts := range buckets {
+		sk := seriesKey{key.queue, key.task}
+		if _, ok := seriesByKey[sk]; !ok {
+			seriesByKey[sk] = &JobStatsSeries{QueueName: key.queue, TaskName: key.task}
+		}
+
+		seriesByKey[sk].Points = append(seriesByKey[sk].Points, JobStatsPoint{
+			Time:      key.bucket,
+			Processed: counts.processed,
+			Failed:    counts.failed,
+			Retried:   counts.retried,
+		})
+	}
+
+	series := make([]JobStatsSeries, 0, len(seriesByKey))
+	for _, s := range seriesByKey {
+		sort.Slice(s.Points, func(i, j int) bool {
+			return s.Points[i].Time.Before(s.Points[j].Time)
+		})
+		series = append(series, *s)
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].QueueName != series[j].QueueName {
+			return series[i].QueueName < series[j].QueueName
+		}
+		return series[i].TaskName < series[j].TaskName
+	})
+
+	return series, nil
+}
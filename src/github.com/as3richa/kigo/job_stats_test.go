@@ -0,0 +1,84 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobStatsRecorderBucketsByMinute(t *testing.T) {
+	recorder := newJobStatsRecorder()
+	listener := recorder.listener(7)
+
+	minute := time.Date(2017, 6, 1, 10, 30, 0, 0, time.UTC)
+	job := &Job{QueueName: "alpha", TaskName: "SyncInvoice"}
+
+	listener(Event{Kind: JobFinishedEvent, WorkerID: 7, Time: minute.Add(5 * time.Second), Job: job})
+	listener(Event{Kind: JobFinishedEvent, WorkerID: 7, Time: minute.Add(55 * time.Second), Job: job})
+	listener(Event{Kind: JobFailedEvent, WorkerID: 7, Time: minute.Add(65 * time.Second), Job: job})
+	listener(Event{Kind: JobFinishedEvent, WorkerID: 8, Time: minute, Job: job})
+	listener(Event{Kind: JobStartedEvent, WorkerID: 7, Time: minute, Job: job})
+
+	if len(recorder.pending) != 2 {
+		t.Fatalf("expected 2 buckets, got %v", recorder.pending)
+	}
+
+	first := recorder.pending[statKey(minute, "alpha", "SyncInvoice")]
+	second := recorder.pending[statKey(minute.Add(time.Minute), "alpha", "SyncInvoice")]
+
+	if first == nil || *first != (jobStatCounts{processed: 2}) {
+		t.Errorf("expected 2 processed in the first minute, got %v", first)
+	}
+
+	if second == nil || *second != (jobStatCounts{failed: 1}) {
+		t.Errorf("expected 1 failed in the second minute, got %v", second)
+	}
+}
+
+func TestJobStatsCompactAndQuery(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		now := time.Now().UTC().Truncate(time.Hour)
+		old := now.Add(-48 * time.Hour)
+
+		recorder := newJobStatsRecorder()
+		recorder.compactedAt = now
+		recorder.add(statKey(old.Add(time.Minute), "alpha", "SyncInvoice"), jobStatCounts{processed: 3})
+		recorder.add(statKey(old.Add(2*time.Minute), "alpha", "SyncInvoice"), jobStatCounts{failed: 1})
+		recorder.add(statKey(now.Add(-time.Minute), "alpha", "SyncInvoice"), jobStatCounts{processed: 1})
+		expectSuccess(t, recorder.flush(c, now))
+
+		id, err := c.pushJobTo("alpha", "SyncInvoice", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+		markRunning(t, c, id)
+		expectSuccess(t, c.failJob(id, errors.New("augh")))
+		expectSuccess(t, c.RetryJob(id))
+
+		expectSuccess(t, c.compactJobStats(now))
+
+		var minuteRows int
+		expectSuccess(t, c.db.Model(&jobStatModel{}).Where("resolution = 60 AND bucket_start < ?", now.Add(-24*time.Hour)).Count(&minuteRows).Error)
+		if minuteRows != 0 {
+			t.Errorf("expected old minute buckets to be compacted, found %d", minuteRows)
+		}
+
+		series, err := c.JobStats(JobStatsQuery{From: old, To: now.Add(time.Hour), Resolution: time.Hour})
+		expectSuccess(t, err)
+
+		if len(series) != 1 || len(series[0].Points) < 2 {
+			t.Fatalf("expected one series with at least 2 points, got %v", series)
+		}
+
+		first := series[0].Points[0]
+		if !first.Time.Equal(old) || first.Processed != 3 || first.Failed != 1 {
+			t.Errorf("expected compacted hour to hold 3 processed and 1 failed, got %+v", first)
+		}
+
+		var retried uint
+		for _, point := range series[0].Points {
+			retried += point.Retried
+		}
+		if retried != 1 {
+			t.Errorf("expected 1 retry, got %d", retried)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package kigo
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const defaultJobPageSize = 50
+const maxJobPageSize = 500
+
+// JobFilter selects jobs by queue, task, state and time ranges. Zero fields
+// don't constrain the selection; time ranges are inclusive.
+type JobFilter struct {
+	QueueName string
+	TaskName  string
+	State     *JobState
+
+	EnqueuedAfter  time.Time
+	EnqueuedBefore time.Time
+	StartAtAfter   time.Time
+	StartAtBefore  time.Time
+	StartedAfter   time.Time
+	StartedBefore  time.Time
+	FinishedAfter  time.Time
+	FinishedBefore time.Time
+}
+
+type JobInfo struct {
+	ID         uint          `json:"id"`
+	QueueName  string        `json:"queueName"`
+	TaskName   string        `json:"taskName"`
+	Parameters []interface{} `json:"parameters"`
+	State      JobState      `json:"state"`
+	Error      *string       `json:"error"`
+
+	EnqueuedAt time.Time  `json:"enqueuedAt"`
+	StartAt    time.Time  `json:"startAt"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+
+	WorkerID   *uint  `json:"workerId"`
+	WorkerName string `json:"workerName,omitempty"`
+
+	Progress *JobProgress `json:"progress,omitempty"`
+}
+
+// JobPage is one page of jobs, newest first. If there are more jobs, Next is
+// the cursor to pass to ListJobs to fetch them; otherwise it is zero.
+type JobPage struct {
+	Jobs []JobInfo `json:"jobs"`
+	Next uint      `json:"next,omitempty"`
+}
+
+func (f JobFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.QueueName != "" {
+		query = query.Where("queue_name = ?", f.QueueName)
+	}
+
+	if f.TaskName != "" {
+		query = query.Where("task_name = ?", f.TaskName)
+	}
+
+	if f.State != nil {
+		query = query.Where("state = ?", *f.State)
+	}
+
+	ranges := []struct {
+		column string
+		after  time.Time
+		before time.Time
+	}{
+		{"enqueued_at", f.EnqueuedAfter, f.EnqueuedBefore},
+		{"start_at", f.StartAtAfter, f.StartAtBefore},
+		{"started_at", f.StartedAfter, f.StartedBefore},
+		{"finished_at", f.FinishedAfter, f.FinishedBefore},
+	}
+
+	for _, r := range ranges {
+		if !r.after.IsZero() {
+			query = query.Where(r.column+" >= ?", r.after)
+		}
+		if !r.before.IsZero() {
+			query = query.Where(r.column+" <= ?", r.before)
+		}
+	}
+
+	return query
+}
+
+// ListJobs returns up to limit jobs matching the filter, newest first. Pages are
+// keyed on job ID, so pass the previous page's Next as cursor (or zero for the
+// first page).
+func (c Connection) ListJobs(filter JobFilter, cursor uint, limit uint) (JobPage, error) {
+	if err := c.requireSQL(); err != nil {
+		return JobPage{}, err
+	}
+
+	if limit == 0 {
+		limit = defaultJobPageSize
+	} else if limit > maxJobPageSize {
+		limit = maxJobPageSize
+	}
+
+	query := filter.apply(c.db.Model(&jobModel{}))
+	if cursor != 0 {
+		query = query.Where("id < ?", cursor)
+	}
+
+	var jobRecords []jobModel
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&jobRecords).Error; err != nil {
+		return JobPage{}, err
+	}
+
+	page := JobPage{Jobs: []JobInfo{}}
+	if uint(len(jobRecords)) > limit {
+		jobRecords = jobRecords[:limit]
+		page.Next = jobRecords[limit-1].ID
+	}
+
+	for _, jobRecord := range jobRecords {
+		page.Jobs = append(page.Jobs, jobRecord.info())
+	}
+
+	return page, nil
+}
+
+// FindJob returns a single job with its parameters decoded, along with the
+// name of the worker running it, if any.
+func (c Connection) FindJob(id uint) (*JobInfo, error) {
+	if err := c.requireSQL(); err != nil {
+		return nil, err
+	}
+
+	var jobRecord jobModel
+	if err := c.db.Where("id = ?", id).First(&jobRecord).Error; err != nil {
+		return nil, err
+	}
+
+	info := jobRecord.info()
+
+	if info.WorkerID != nil {
+		var workerRecord workerModel
+		err := c.db.Select("name").Where("id = ?", *info.WorkerID).First(&workerRecord).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		info.WorkerName = workerRecord.Name
+	}
+
+	return &info, nil
+}
+
+func (j jobModel) info() JobInfo {
+	info := JobInfo{
+		ID:         j.ID,
+		QueueName:  j.QueueName,
+		TaskName:   j.TaskName,
+		State:      j.State,
+		Error:      j.Error,
+		EnqueuedAt: j.EnqueuedAt,
+		StartAt:    j.StartAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		WorkerID:   j.WorkerID,
+		Progress:   j.progress(),
+	}
+
+	if job, err := decodeJob(j); err == nil {
+		info.Parameters = job.Parameters
+	}
+
+	return info
+}
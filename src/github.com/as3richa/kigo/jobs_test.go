@@ -0,0 +1,61 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListJobsPaginatesAndFilters(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		var ids []uint
+		for i := 0; i < 7; i++ {
+			id, err := c.pushJobTo("alpha", "list", []interface{}{i}, time.Now())
+			expectSuccess(t, err)
+			ids = append(ids, id)
+		}
+
+		_, err := c.pushJobTo("beta", "list", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		markRunning(t, c, ids[0])
+		expectSuccess(t, c.failJob(ids[0], errors.New("augh")))
+
+		filter := JobFilter{QueueName: "alpha"}
+		var seen []uint
+
+		for cursor, pages := uint(0), 0; ; pages++ {
+			page, err := c.ListJobs(filter, cursor, 3)
+			expectSuccess(t, err)
+
+			for _, job := range page.Jobs {
+				seen = append(seen, job.ID)
+			}
+
+			if page.Next == 0 {
+				if pages != 2 {
+					t.Errorf("expected 3 pages, got %d", pages+1)
+				}
+				break
+			}
+			cursor = page.Next
+		}
+
+		if len(seen) != 7 || seen[0] != ids[6] || seen[6] != ids[0] {
+			t.Errorf("expected alpha jobs newest first, got %v", seen)
+		}
+
+		failed := JobFailed
+		page, err := c.ListJobs(JobFilter{State: &failed, FinishedAfter: time.Now().Add(-time.Minute)}, 0, 0)
+		expectSuccess(t, err)
+		if len(page.Jobs) != 1 || page.Jobs[0].ID != ids[0] || page.Jobs[0].FinishedAt == nil {
+			t.Errorf("expected only the failed job, got %v", page.Jobs)
+		}
+
+		info, err := c.FindJob(ids[3])
+		expectSuccess(t, err)
+		if len(info.Parameters) != 1 || info.Parameters[0] != 3 {
+			t.Errorf("expected decoded parameters [3], got %v", info.Parameters)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+// Package kigotest provides kigo Connections for application tests, which
+// need neither a database nor a worker.
+//
+// In Fake mode, jobs enqueued through the Connection are recorded, so that
+// tests can assert on them, and only run when the test calls Drain. In Inline
+// mode, each job runs synchronously as it is enqueued, before PerformTask*
+// returns. In both modes, jobs run through the registered tasks and the server
+// middleware, and their outcomes can be fetched through the Connection with
+// JobResult, WaitForJob, JobProgress and JobLogs. Jobs report their events to
+// the Harness's own EventBus, and its failures are collected by Errors.
+package kigotest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/as3richa/kigo"
+)
+
+type Mode uint
+
+const (
+	Fake Mode = iota
+	Inline
+)
+
+// Job is a snapshot of a job enqueued through a Harness's Connection. Its
+// parameters have been through the same serialization as on a real queue.
+type Job struct {
+	ID         uint
+	QueueName  string
+	TaskName   string
+	Parameters []interface{}
+	StartAt    time.Time
+
+	State kigo.JobState
+	Error error
+}
+
+type Harness struct {
+	mode       Mode
+	store      *kigo.MemoryStore
+	events     *kigo.EventBus
+	connection kigo.Connection
+
+	errorsMutex sync.Mutex
+	errors      []error
+}
+
+func New(mode Mode) *Harness {
+	h := &Harness{mode: mode, events: kigo.NewEventBus()}
+
+	var options kigo.MemoryOptions
+	if mode == Inline {
+		options.PushHook = func(id uint) {
+			if job, ok := h.store.ClaimJob(id); ok {
+				h.execute(job)
+			}
+		}
+	}
+
+	// Without a log, the store can't fail to open
+	store, err := kigo.NewMemoryStore(options)
+	if err != nil {
+		panic(err)
+	}
+
+	h.store = store
+	h.connection = kigo.NewConnection(store).WithEventBus(h.events)
+	return h
+}
+
+func NewFake() *Harness {
+	return New(Fake)
+}
+
+func NewInline() *Harness {
+	return New(Inline)
+}
+
+// Connection returns the Connection through which the code under test should
+// enqueue jobs. Operations which need SQL, such as ListJobs, fail with
+// kigo.ErrUnsupported.
+func (h *Harness) Connection() kigo.Connection {
+	return h.connection
+}
+
+func (h *Harness) Mode() Mode {
+	return h.mode
+}
+
+// EventBus returns the bus on which the harness's Connection emits its events,
+// from enqueueing jobs to running them, in place of kigo.DefaultEventBus.
+func (h *Harness) EventBus() *kigo.EventBus {
+	return h.events
+}
+
+// Jobs returns every job enqueued so far, in the order they were enqueued.
+func (h *Harness) Jobs() []Job {
+	return h.jobs(func(Job) bool { return true })
+}
+
+// Enqueued returns the jobs which haven't run yet, in the order they were
+// enqueued, including those scheduled for later.
+func (h *Harness) Enqueued() []Job {
+	return h.jobs(func(job Job) bool { return job.State == kigo.JobEnqueued })
+}
+
+// EnqueuedFor returns the jobs of the given task which haven't run yet.
+func (h *Harness) EnqueuedFor(taskName string) []Job {
+	return h.jobs(func(job Job) bool {
+		return job.State == kigo.JobEnqueued && job.TaskName == taskName
+	})
+}
+
+func (h *Harness) jobs(include func(Job) bool) []Job {
+	var jobs []Job
+	for _, stored := range h.store.Jobs() {
+		job := Job{
+			ID:        stored.ID,
+			QueueName: stored.QueueName,
+			TaskName:  stored.TaskName,
+			StartAt:   stored.StartAt,
+			State:     stored.State,
+		}
+
+		if decoded, err := stored.Decode(); err == nil {
+			job.Parameters = decoded.Parameters
+		}
+
+		if stored.Error != nil {
+			job.Error = errors.New(*stored.Error)
+		}
+
+		if include(job) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs
+}
+
+// AssertEnqueued fails the test unless a job of the given task, with exactly
+// the given parameters, is waiting to run on the given queue.
+func (h *Harness) AssertEnqueued(t testing.TB, queueName string, taskName string, parameters ...interface{}) {
+	t.Helper()
+
+	if parameters == nil {
+		parameters = []interface{}{}
+	}
+
+	enqueued := h.Enqueued()
+	for _, job := range enqueued {
+		if job.QueueName == queueName && job.TaskName == taskName && reflect.DeepEqual(job.Parameters, parameters) {
+			return
+		}
+	}
+
+	t.Errorf("expected %s%v to be enqueued on %s, but found:%s", taskName, parameters, queueName, describe(enqueued))
+}
+
+// AssertNoneEnqueued fails the test if any job is waiting to run.
+func (h *Harness) AssertNoneEnqueued(t testing.TB) {
+	t.Helper()
+
+	if enqueued := h.Enqueued(); len(enqueued) != 0 {
+		t.Errorf("expected no jobs to be enqueued, but found:%s", describe(enqueued))
+	}
+}
+
+func describe(jobs []Job) string {
+	if len(jobs) == 0 {
+		return " nothing"
+	}
+
+	description := ""
+	for _, job := range jobs {
+		description += fmt.Sprintf("\n  %d: %s%v on %s", job.ID, job.TaskName, job.Parameters, job.QueueName)
+	}
+	return description
+}
+
+// Drain runs the enqueued jobs one at a time, oldest first and regardless of
+// when they were scheduled, until none remain; jobs enqueued by the jobs
+// being run are run too. It returns an error describing the first job which
+// failed, if any did.
+func (h *Harness) Drain() error {
+	var firstErr error
+
+	for {
+		job, ok := h.claimNext()
+		if !ok {
+			return firstErr
+		}
+
+		if err := h.execute(job); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+}
+
+func (h *Harness) claimNext() (kigo.EncodedJob, bool) {
+	for _, job := range h.store.Jobs() {
+		if job.State != kigo.JobEnqueued {
+			continue
+		}
+
+		if claimed, ok := h.store.ClaimJob(job.ID); ok {
+			return claimed, true
+		}
+	}
+	return kigo.EncodedJob{}, false
+}
+
+// execute runs a claimed job, and records an error describing its failure, if
+// it failed.
+func (h *Harness) execute(job kigo.EncodedJob) error {
+	err := h.connection.ExecuteJob(job, kigo.ExecuteOptions{})
+	if err == nil {
+		return nil
+	}
+
+	err = fmt.Errorf("job %d (%s) failed: %v", job.ID, job.TaskName, err)
+
+	h.errorsMutex.Lock()
+	h.errors = append(h.errors, err)
+	h.errorsMutex.Unlock()
+
+	return err
+}
+
+// Errors returns an error describing each job which failed, in the order they
+// failed, whether they ran inline or were drained.
+func (h *Harness) Errors() []error {
+	h.errorsMutex.Lock()
+	defer h.errorsMutex.Unlock()
+
+	return append([]error(nil), h.errors...)
+}
+
+// Clear forgets every job, and every failure.
+func (h *Harness) Clear() {
+	h.store.Clear()
+
+	h.errorsMutex.Lock()
+	h.errors = nil
+	h.errorsMutex.Unlock()
+}
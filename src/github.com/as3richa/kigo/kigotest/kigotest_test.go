@@ -0,0 +1,177 @@
+package kigotest
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as3richa/kigo"
+)
+
+var exported []string
+
+func init() {
+	kigo.RegisterTask("kigotestExport", func(report string, rows int) (int, error) {
+		exported = append(exported, report)
+		return rows * 2, nil
+	})
+
+	kigo.RegisterTask("kigotestFanOut", func(context *kigo.TaskContext, reports []string) error {
+		// Tasks reach the harness through the same package-level connection
+		// the application would use
+		for _, report := range reports {
+			if _, err := fanOutConnection.PerformTaskOnQueue("kigotestExport", []interface{}{report, 1}, "exports"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	kigo.RegisterTask("kigotestFail", func() error { return errors.New("augh") })
+}
+
+var fanOutConnection kigo.Connection
+
+func TestFakeRecordsJobsUntilDrained(t *testing.T) {
+	exported = nil
+
+	h := NewFake()
+	c := h.Connection()
+	fanOutConnection = c
+
+	id, err := c.PerformTaskOnQueue("kigotestExport", []interface{}{"monthly", 10}, "exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.PerformTaskAt("kigotestFanOut", []interface{}{[]string{"a", "b"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.AssertEnqueued(t, "exports", "kigotestExport", "monthly", 10)
+	h.AssertEnqueued(t, "default", "kigotestFanOut", []string{"a", "b"})
+
+	if jobs := h.EnqueuedFor("kigotestExport"); len(jobs) != 1 || jobs[0].ID != id {
+		t.Errorf("expected only job %d for kigotestExport, got %v", id, jobs)
+	}
+
+	if len(exported) != 0 {
+		t.Fatalf("expected nothing to run before draining, got %v", exported)
+	}
+
+	if err := h.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	h.AssertNoneEnqueued(t)
+
+	if len(exported) != 3 || exported[0] != "monthly" || exported[1] != "a" || exported[2] != "b" {
+		t.Errorf("expected the reports to be exported in order, got %v", exported)
+	}
+
+	var rows int
+	if err := c.JobResult(id, &rows); err != nil || rows != 20 {
+		t.Errorf("expected a result of 20, got %d (%v)", rows, err)
+	}
+
+	if len(h.Jobs()) != 4 {
+		t.Errorf("expected 4 jobs in all, got %v", h.Jobs())
+	}
+
+	h.Clear()
+	if len(h.Jobs()) != 0 {
+		t.Errorf("expected no jobs after clearing, got %v", h.Jobs())
+	}
+}
+
+func TestDrainReportsFailures(t *testing.T) {
+	h := NewFake()
+
+	id, err := h.Connection().PerformTask("kigotestFail", []interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Drain(); err == nil {
+		t.Fatal("expected draining to report the failure")
+	}
+
+	outcome, err := h.Connection().WaitForJob(id, time.Second)
+	if err != nil || outcome.State != kigo.JobFailed {
+		t.Errorf("expected the job to have failed, got %v (%v)", outcome, err)
+	}
+}
+
+func TestInlineRunsJobsWhenEnqueued(t *testing.T) {
+	exported = nil
+
+	h := NewInline()
+	c := h.Connection()
+	fanOutConnection = c
+
+	if _, err := c.PerformTask("kigotestFanOut", []interface{}{[]string{"x", "y"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exported) != 2 || exported[0] != "x" || exported[1] != "y" {
+		t.Errorf("expected the reports to be exported immediately, got %v", exported)
+	}
+
+	h.AssertNoneEnqueued(t)
+
+	id, err := c.PerformTask("kigotestFail", []interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := h.Jobs()
+	if last := jobs[len(jobs)-1]; last.ID != id || last.State != kigo.JobFailed || last.Error == nil {
+		t.Errorf("expected the failing job to have failed, got %+v", last)
+	}
+
+	if errs := h.Errors(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "augh") {
+		t.Errorf("expected the failure to be recorded, got %v", errs)
+	}
+
+	h.Clear()
+	if errs := h.Errors(); len(errs) != 0 {
+		t.Errorf("expected no failures after clearing, got %v", errs)
+	}
+
+	if _, err := c.ListJobs(kigo.JobFilter{}, 0, 0); err != kigo.ErrUnsupported {
+		t.Errorf("expected %v from ListJobs, got %v", kigo.ErrUnsupported, err)
+	}
+}
+
+func TestHarnessReportsEventsToItsOwnBus(t *testing.T) {
+	h := NewFake()
+
+	var kinds []kigo.EventKind
+	h.EventBus().Subscribe(func(event kigo.Event) {
+		kinds = append(kinds, event.Kind)
+	})
+
+	var leaked bool
+	unsubscribe := kigo.Subscribe(func(kigo.Event) { leaked = true })
+	defer unsubscribe()
+
+	if _, err := h.Connection().PerformTask("kigotestFail", []interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Drain(); err == nil {
+		t.Fatal("expected draining to report the failure")
+	}
+
+	expected := []kigo.EventKind{kigo.JobEnqueuedEvent, kigo.JobClaimedEvent, kigo.JobStartedEvent, kigo.JobFailedEvent, kigo.JobDeadEvent}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("expected events %v, got %v", expected, kinds)
+	}
+
+	if leaked {
+		t.Error("expected nothing to be reported to the default bus")
+	}
+}
@@ -0,0 +1,730 @@
+package kigo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const memoryScheme = "memory://"
+
+// ErrMemoryLogClosed is returned by a memory store whose log has been closed,
+// rather than accepting jobs it can't write to the log.
+var ErrMemoryLogClosed = errors.New("memory store's log is closed")
+
+const defaultMemoryRetention = 24 * time.Hour
+
+// The log is rewritten once it holds at least this many entries for jobs
+// which have completed, and more of them than of jobs still outstanding.
+const memoryLogCompactionThreshold = 1000
+
+// MemoryOptions configures a Connection which keeps jobs in memory.
+type MemoryOptions struct {
+	// LogPath, if set, names an append-only file to which enqueued jobs and
+	// their completion are written, so that jobs which hadn't completed are
+	// enqueued again when the process restarts. Jobs which were running at
+	// the time are run again.
+	LogPath string
+
+	// Retention is how long finished and failed jobs are kept, so that their
+	// outcomes can be fetched; it defaults to a day.
+	Retention time.Duration
+
+	// PushHook, if set, is called with each job's id after it's enqueued,
+	// without the store's lock held. kigotest uses it to run jobs as they're
+	// enqueued.
+	PushHook func(id uint)
+}
+
+// ConnectMemory returns a Connection whose jobs live in this process's memory,
+// for small services and command-line tools which have no database. It
+// supports enqueueing jobs, running workers, and fetching jobs' outcomes,
+// progress and logs; operations which need SQL fail with ErrUnsupported.
+// Connect accepts memory:// and memory:///path/to/log URLs as shorthand.
+//
+// Without a LogPath, jobs are only shared by copies of the returned
+// Connection. Connections with the same LogPath share one store, configured by
+// the first of them, and the log is closed once every one of them has been
+// closed. A log mustn't be shared with another process.
+func ConnectMemory(options MemoryOptions) (Connection, error) {
+	if options.LogPath == "" {
+		waiters := newJobWaiters()
+
+		store, err := openMemoryStore(options, waiters.wake)
+		if err != nil {
+			return Connection{}, err
+		}
+
+		return Connection{store: store, waiters: waiters, closer: newConnectionCloser(store.close)}, nil
+	}
+
+	path, err := filepath.Abs(options.LogPath)
+	if err != nil {
+		return Connection{}, err
+	}
+
+	memoryStores.Lock()
+	defer memoryStores.Unlock()
+
+	shared, ok := memoryStores.byPath[path]
+	if !ok {
+		waiters := newJobWaiters()
+
+		options.LogPath = path
+		store, err := openMemoryStore(options, waiters.wake)
+		if err != nil {
+			return Connection{}, err
+		}
+
+		shared = &sharedMemoryStore{store: store, waiters: waiters}
+		memoryStores.byPath[path] = shared
+	}
+
+	shared.refs++
+
+	return Connection{store: shared.store, waiters: shared.waiters, closer: newConnectionCloser(func() error {
+		return releaseMemoryStore(path)
+	})}, nil
+}
+
+// memoryStores holds the stores with logs, by absolute log path, so that
+// connections to the same log share a store rather than both appending to it.
+var memoryStores = struct {
+	sync.Mutex
+	byPath map[string]*sharedMemoryStore
+}{byPath: map[string]*sharedMemoryStore{}}
+
+type sharedMemoryStore struct {
+	store   *memoryStore
+	waiters *jobWaiters
+	refs    uint
+}
+
+// releaseMemoryStore drops a connection's reference to a shared store, closing
+// its log when no connections remain.
+func releaseMemoryStore(path string) error {
+	memoryStores.Lock()
+	defer memoryStores.Unlock()
+
+	shared := memoryStores.byPath[path]
+	shared.refs--
+	if shared.refs != 0 {
+		return nil
+	}
+
+	delete(memoryStores.byPath, path)
+	return shared.store.close()
+}
+
+type memoryJob struct {
+	EncodedJob
+
+	state    JobState
+	workerID uint
+
+	enqueuedAt time.Time
+	startAt    time.Time
+	finishedAt time.Time
+
+	result   []byte
+	error    *string
+	progress *JobProgress
+	logs     []JobLogLine
+}
+
+type memoryWorker struct {
+	name        string
+	queueNames  []string
+	concurrency uint
+	heartbeatAt time.Time
+}
+
+// memoryLogEntry is a line of the log. A push entry records an enqueued job,
+// a done entry records that it completed, and a next entry, which starts a
+// compacted log, records the next job id to allocate.
+type memoryLogEntry struct {
+	Op         string    `json:"op"`
+	ID         uint      `json:"id"`
+	QueueName  string    `json:"queue,omitempty"`
+	TaskName   string    `json:"task,omitempty"`
+	ParamBlob  []byte    `json:"params,omitempty"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	StartAt    time.Time `json:"startAt"`
+}
+
+// memoryStore implements Store in memory. Outstanding jobs are kept in id
+// order, which is also the order in which they were enqueued.
+type memoryStore struct {
+	sync.Mutex
+
+	retention time.Duration
+	onDone    func(uint)
+	pushHook  func(uint)
+
+	logPath    string
+	log        *os.File
+	logGarbage uint
+
+	nextWorkerID uint
+	nextJobID    uint
+
+	workers     map[uint]*memoryWorker
+	jobs        map[uint]*memoryJob
+	outstanding []*memoryJob
+	completed   []*memoryJob
+}
+
+func openMemoryStore(options MemoryOptions, onDone func(uint)) (*memoryStore, error) {
+	s := &memoryStore{
+		retention:    options.Retention,
+		onDone:       onDone,
+		pushHook:     options.PushHook,
+		logPath:      options.LogPath,
+		nextWorkerID: 1,
+		nextJobID:    1,
+		workers:      map[uint]*memoryWorker{},
+		jobs:         map[uint]*memoryJob{},
+	}
+
+	if s.retention <= 0 {
+		s.retention = defaultMemoryRetention
+	}
+
+	if s.logPath == "" {
+		return s, nil
+	}
+
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compactLog(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replayLog enqueues the jobs in the log which hadn't completed. A partial
+// final line, left by a crash in the middle of a write, is ignored.
+func (s *memoryStore) replayLog() error {
+	file, err := os.Open(s.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pending := map[uint]*memoryJob{}
+	reader := bufio.NewReader(file)
+
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		var entry memoryLogEntry
+		if err := json.Unmarshal(text, &entry); err != nil {
+			return fmt.Errorf("corrupt job log %s at line %d: %v", s.logPath, line, err)
+		}
+
+		if entry.ID >= s.nextJobID {
+			s.nextJobID = entry.ID + 1
+		}
+
+		switch entry.Op {
+		case "push":
+			pending[entry.ID] = &memoryJob{
+				EncodedJob: EncodedJob{
+					ID:        entry.ID,
+					QueueName: entry.QueueName,
+					TaskName:  entry.TaskName,
+					ParamBlob: entry.ParamBlob,
+				},
+				state:      JobEnqueued,
+				enqueuedAt: entry.EnqueuedAt,
+				startAt:    entry.StartAt,
+			}
+		case "done":
+			delete(pending, entry.ID)
+		case "next":
+			if entry.ID > s.nextJobID {
+				s.nextJobID = entry.ID
+			}
+		}
+	}
+
+	for id, job := range pending {
+		s.jobs[id] = job
+		s.outstanding = append(s.outstanding, job)
+	}
+
+	sort.Slice(s.outstanding, func(i, j int) bool {
+		return s.outstanding[i].ID < s.outstanding[j].ID
+	})
+
+	return nil
+}
+
+// compactLog rewrites the log with only the outstanding jobs, and keeps it
+// open for appending. The old log stays in use until its replacement is in
+// place, so a failed compaction leaves it intact.
+func (s *memoryStore) compactLog() error {
+	temporaryPath := s.logPath + ".tmp"
+
+	file, err := os.OpenFile(temporaryPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	err = encoder.Encode(memoryLogEntry{Op: "next", ID: s.nextJobID})
+	for _, job := range s.outstanding {
+		if err != nil {
+			break
+		}
+		err = encoder.Encode(job.logEntry())
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, s.logPath)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(temporaryPath)
+		return err
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+
+	s.log = file
+	s.logGarbage = 0
+	return nil
+}
+
+func (job *memoryJob) logEntry() memoryLogEntry {
+	return memoryLogEntry{
+		Op:         "push",
+		ID:         job.ID,
+		QueueName:  job.QueueName,
+		TaskName:   job.TaskName,
+		ParamBlob:  job.ParamBlob,
+		EnqueuedAt: job.enqueuedAt,
+		StartAt:    job.startAt,
+	}
+}
+
+// appendLog writes an entry to the log, if there is one. Pushes are synced to
+// disk before they're acknowledged; losing a done entry only means running a
+// job again.
+func (s *memoryStore) appendLog(entry memoryLogEntry, sync bool) error {
+	if s.logPath == "" {
+		return nil
+	}
+
+	if s.log == nil {
+		return ErrMemoryLogClosed
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.log.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	if sync {
+		return s.log.Sync()
+	}
+	return nil
+}
+
+func (s *memoryStore) close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.log == nil {
+		return nil
+	}
+
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+func (s *memoryStore) CreateWorker(name string, queueNames []string, concurrency uint) (uint, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	id := s.nextWorkerID
+	s.nextWorkerID++
+
+	s.workers[id] = &memoryWorker{
+		name:        name,
+		queueNames:  append([]string(nil), queueNames...),
+		concurrency: concurrency,
+		heartbeatAt: time.Now(),
+	}
+
+	return id, nil
+}
+
+func (s *memoryStore) Ping() error {
+	return nil
+}
+
+func (s *memoryStore) BeatWorker(id uint) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if worker, ok := s.workers[id]; ok {
+		worker.heartbeatAt = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryStore) TerminateWorker(id uint) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var err error
+	message := "worker was terminated"
+
+	for _, job := range s.jobs {
+		if job.state == JobRunning && job.workerID == id {
+			if completeErr := s.complete(job, JobFailed, nil, &message); completeErr != nil {
+				err = completeErr
+			}
+		}
+	}
+
+	delete(s.workers, id)
+	return err
+}
+
+func (s *memoryStore) PushJob(queueName string, taskName string, paramBlob []byte, startAt time.Time) (uint, error) {
+	id, err := s.pushJob(queueName, taskName, paramBlob, startAt)
+	if err == nil && s.pushHook != nil {
+		s.pushHook(id)
+	}
+	return id, err
+}
+
+func (s *memoryStore) pushJob(queueName string, taskName string, paramBlob []byte, startAt time.Time) (uint, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job := &memoryJob{
+		EncodedJob: EncodedJob{
+			ID:        s.nextJobID,
+			QueueName: queueName,
+			TaskName:  taskName,
+			ParamBlob: paramBlob,
+		},
+		state:      JobEnqueued,
+		enqueuedAt: time.Now(),
+		startAt:    startAt,
+	}
+
+	if err := s.appendLog(job.logEntry(), true); err != nil {
+		return 0, err
+	}
+
+	s.nextJobID++
+	s.jobs[job.ID] = job
+	s.outstanding = append(s.outstanding, job)
+
+	return job.ID, nil
+}
+
+func (s *memoryStore) ClaimJobs(workerID uint, queueNames []string, limit uint) ([]EncodedJob, error) {
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.expireCompleted(now)
+
+	wanted := map[string]bool{}
+	for _, queueName := range queueNames {
+		wanted[queueName] = true
+	}
+
+	var jobs []EncodedJob
+	for _, job := range s.outstanding {
+		if uint(len(jobs)) >= limit {
+			break
+		}
+
+		if job.state != JobEnqueued || !wanted[job.QueueName] || job.startAt.After(now) {
+			continue
+		}
+
+		job.state = JobRunning
+		job.workerID = workerID
+		jobs = append(jobs, job.EncodedJob)
+	}
+
+	return jobs, nil
+}
+
+func (s *memoryStore) FinishJob(id uint, result []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if job, ok := s.jobs[id]; ok && job.state == JobRunning {
+		return s.complete(job, JobFinished, result, nil)
+	}
+	return ErrJobNotRunning
+}
+
+func (s *memoryStore) FailJob(id uint, message string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if job, ok := s.jobs[id]; ok && job.state == JobRunning {
+		return s.complete(job, JobFailed, nil, &message)
+	}
+	return ErrJobNotRunning
+}
+
+func (s *memoryStore) CancelJob(id uint) (EncodedJob, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return EncodedJob{}, gorm.ErrRecordNotFound
+	}
+
+	if job.state != JobEnqueued {
+		return EncodedJob{}, ErrJobNotEnqueued
+	}
+
+	message := cancelledJobMessage
+	return job.EncodedJob, s.complete(job, JobFailed, nil, &message)
+}
+
+// complete moves an outstanding job to the completed jobs, logs its
+// completion, and wakes anybody waiting for it.
+func (s *memoryStore) complete(job *memoryJob, state JobState, result []byte, message *string) error {
+	job.state = state
+	job.workerID = 0
+	job.finishedAt = time.Now()
+	job.result = result
+	job.error = message
+
+	for i, outstanding := range s.outstanding {
+		if outstanding == job {
+			s.outstanding = append(s.outstanding[:i], s.outstanding[i+1:]...)
+			break
+		}
+	}
+	s.completed = append(s.completed, job)
+
+	if s.onDone != nil {
+		s.onDone(job.ID)
+	}
+
+	if s.logPath == "" {
+		return nil
+	}
+
+	if err := s.appendLog(memoryLogEntry{Op: "done", ID: job.ID}, false); err != nil {
+		return err
+	}
+
+	s.logGarbage += 2
+	if s.logGarbage >= memoryLogCompactionThreshold && s.logGarbage > uint(len(s.outstanding)) {
+		return s.compactLog()
+	}
+
+	return nil
+}
+
+// expireCompleted forgets jobs which completed longer ago than the retention
+// period. Jobs complete in order, so they're expired from the front.
+func (s *memoryStore) expireCompleted(now time.Time) {
+	cutoff := now.Add(-s.retention)
+
+	expired := 0
+	for expired < len(s.completed) && s.completed[expired].finishedAt.Before(cutoff) {
+		delete(s.jobs, s.completed[expired].ID)
+		expired++
+	}
+
+	s.completed = s.completed[expired:]
+}
+
+func (s *memoryStore) FetchJobOutcome(id uint) (EncodedOutcome, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return EncodedOutcome{}, gorm.ErrRecordNotFound
+	}
+
+	return EncodedOutcome{State: job.state, Error: job.error, ResultBlob: job.result}, nil
+}
+
+func (s *memoryStore) SaveJobProgress(id uint, progress JobProgress) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if job, ok := s.jobs[id]; ok && job.state == JobRunning {
+		job.progress = &progress
+	}
+	return nil
+}
+
+func (s *memoryStore) FetchJobProgress(id uint) (*JobProgress, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if job.progress == nil {
+		return nil, nil
+	}
+
+	progress := *job.progress
+	return &progress, nil
+}
+
+func (s *memoryStore) AppendJobLogs(id uint, lines []JobLogLine, keep uint) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		job.logs = append(job.logs, lines...)
+		if uint(len(job.logs)) > keep {
+			job.logs = append([]JobLogLine(nil), job.logs[uint(len(job.logs))-keep:]...)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) FetchJobLogs(id uint) ([]JobLogLine, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return append([]JobLogLine{}, job.logs...), nil
+}
+
+// MemoryStore is the store behind ConnectMemory, for harnesses such as
+// kigotest which inspect and run its jobs directly. Pass it to NewConnection
+// to enqueue and run jobs through it.
+type MemoryStore struct {
+	*memoryStore
+}
+
+// MemoryJob is a snapshot of a job in a MemoryStore.
+type MemoryJob struct {
+	EncodedJob
+
+	StartAt time.Time
+	State   JobState
+	Error   *string
+}
+
+// NewMemoryStore returns an empty MemoryStore, or one holding the outstanding
+// jobs of options.LogPath. Unlike ConnectMemory, it never shares a log with
+// other connections; WaitForJob polls it rather than being woken up.
+func NewMemoryStore(options MemoryOptions) (*MemoryStore, error) {
+	store, err := openMemoryStore(options, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryStore{store}, nil
+}
+
+// Jobs returns every job the store holds, in the order they were enqueued.
+// Completed jobs are held for the retention period.
+func (s *MemoryStore) Jobs() []MemoryJob {
+	s.Lock()
+	defer s.Unlock()
+
+	jobs := make([]MemoryJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, MemoryJob{EncodedJob: job.EncodedJob, StartAt: job.startAt, State: job.state, Error: job.error})
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs
+}
+
+// ClaimJob marks an enqueued job as running, whether or not it's due yet, and
+// returns it. It returns false if the job isn't enqueued.
+func (s *MemoryStore) ClaimJob(id uint) (EncodedJob, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.state != JobEnqueued {
+		return EncodedJob{}, false
+	}
+
+	job.state = JobRunning
+	return job.EncodedJob, true
+}
+
+// Clear forgets every job.
+func (s *MemoryStore) Clear() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.jobs = map[uint]*memoryJob{}
+	s.outstanding = nil
+	s.completed = nil
+
+	if s.logPath == "" {
+		return nil
+	}
+
+	if s.log == nil {
+		return ErrMemoryLogClosed
+	}
+	return s.compactLog()
+}
+
+// Close closes the store's log, if it has one.
+func (s *MemoryStore) Close() error {
+	return s.close()
+}
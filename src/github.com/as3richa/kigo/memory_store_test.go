@@ -0,0 +1,255 @@
+package kigo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMemoryConnectionRunsWorkers(t *testing.T) {
+	RegisterTask("memoryDouble", func(n int) (int, error) { return 2 * n, nil })
+
+	c, err := Connect("memory://")
+	expectSuccess(t, err)
+	expectSuccess(t, c.Migrate())
+
+	id, err := c.PerformTaskOnQueue("memoryDouble", []interface{}{21}, "memory")
+	expectSuccess(t, err)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	terminator := make(chan struct{})
+	stopped := make(chan error)
+
+	go func() {
+		stopped <- c.RunWorkerWithOptions([]string{"memory"}, 2, &WorkerOptions{
+			Logger:     logger,
+			Terminator: terminator,
+			EventBus:   NewEventBus(),
+			Retention:  &RetentionPolicy{Default: Retention{Finished: time.Hour}},
+		})
+	}()
+
+	outcome, err := c.WaitForJob(id, 5*time.Second)
+	expectSuccess(t, err)
+	if outcome.State != JobFinished {
+		t.Fatalf("expected the job to finish, got %v", outcome)
+	}
+
+	var result int
+	expectSuccess(t, c.JobResult(id, &result))
+	if result != 42 {
+		t.Errorf("expected 42, got %d", result)
+	}
+
+	close(terminator)
+	expectSuccess(t, <-stopped)
+
+	if _, err := c.ListJobs(JobFilter{}, 0, 0); err != ErrUnsupported {
+		t.Errorf("expected %v from ListJobs, got %v", ErrUnsupported, err)
+	}
+}
+
+func TestMemoryStoreClaimsInOrder(t *testing.T) {
+	c, err := ConnectMemory(MemoryOptions{})
+	expectSuccess(t, err)
+
+	workerID, err := c.createWorker("memory", []string{"alpha", "beta"}, 10)
+	expectSuccess(t, err)
+
+	first, _ := c.pushJobTo("alpha", "claim", []interface{}{}, time.Now())
+	_, _ = c.pushJobTo("gamma", "claim", []interface{}{}, time.Now())
+	_, _ = c.pushJobTo("beta", "claim", []interface{}{}, time.Now().Add(time.Hour))
+	second, _ := c.pushJobTo("beta", "claim", []interface{}{}, time.Now())
+	third, _ := c.pushJobTo("alpha", "claim", []interface{}{}, time.Now())
+
+	jobs, _, err := c.popJobsFrom(workerID, []string{"alpha", "beta"}, 2)
+	expectSuccess(t, err)
+	if len(jobs) != 2 || jobs[0].ID != first || jobs[1].ID != second {
+		t.Fatalf("expected jobs %d and %d, got %v", first, second, jobs)
+	}
+
+	jobs, _, err = c.popJobsFrom(workerID, []string{"alpha", "beta"}, 2)
+	expectSuccess(t, err)
+	if len(jobs) != 1 || jobs[0].ID != third {
+		t.Fatalf("expected job %d, got %v", third, jobs)
+	}
+
+	expectSuccess(t, c.terminateWorker(workerID))
+
+	outcome, err := c.fetchJobOutcome(first)
+	expectSuccess(t, err)
+	if outcome.State != JobFailed || outcome.Error == nil {
+		t.Errorf("expected the terminated worker's job to fail, got %v", outcome)
+	}
+
+	if err := c.finishJob(first, nil); err != ErrJobNotRunning {
+		t.Errorf("expected %v finishing a job which was failed, got %v", ErrJobNotRunning, err)
+	}
+
+	if _, err := c.fetchJobOutcome(1000); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected %v for a missing job, got %v", gorm.ErrRecordNotFound, err)
+	}
+
+	scheduled, _ := c.pushJobTo("beta", "claim", []interface{}{}, time.Now().Add(time.Hour))
+	expectSuccess(t, c.CancelJob(scheduled))
+
+	outcome, err = c.fetchJobOutcome(scheduled)
+	expectSuccess(t, err)
+	if outcome.State != JobFailed || outcome.Error == nil || outcome.Error.Error() != cancelledJobMessage {
+		t.Errorf("expected the cancelled job to fail, got %v", outcome)
+	}
+
+	if err := c.CancelJob(scheduled); err != ErrJobNotEnqueued {
+		t.Errorf("expected ErrJobNotEnqueued, got %v", err)
+	}
+}
+
+func TestMemoryLogSurvivesRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kigotest")
+	expectSuccess(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jobs.log")
+
+	c, err := Connect(memoryScheme + path)
+	expectSuccess(t, err)
+
+	workerID, err := c.createWorker("memory", []string{"default"}, 10)
+	expectSuccess(t, err)
+
+	finished, _ := c.pushJobTo("default", "survive", []interface{}{1}, time.Now())
+	running, _ := c.pushJobTo("default", "survive", []interface{}{"two"}, time.Now())
+	scheduled, _ := c.pushJobTo("default", "survive", []interface{}{3}, time.Now().Add(time.Hour))
+
+	jobs, _, err := c.popJobsFrom(workerID, []string{"default"}, 2)
+	expectSuccess(t, err)
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %v", jobs)
+	}
+	expectSuccess(t, c.finishJob(finished, nil))
+	expectSuccess(t, c.Close())
+
+	// Simulate a crash in the middle of appending an entry
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	expectSuccess(t, err)
+	_, err = file.WriteString(`{"op":"pu`)
+	expectSuccess(t, err)
+	file.Close()
+
+	c, err = Connect(memoryScheme + path)
+	expectSuccess(t, err)
+	defer c.Close()
+
+	if _, err := c.fetchJobOutcome(finished); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected the finished job to be gone, got %v", err)
+	}
+
+	outcome, err := c.fetchJobOutcome(scheduled)
+	expectSuccess(t, err)
+	if outcome.State != JobEnqueued {
+		t.Errorf("expected the scheduled job to be enqueued, got %v", outcome)
+	}
+
+	workerID, err = c.createWorker("memory", []string{"default"}, 10)
+	expectSuccess(t, err)
+
+	jobs, _, err = c.popJobsFrom(workerID, []string{"default"}, 10)
+	expectSuccess(t, err)
+	if len(jobs) != 1 || jobs[0].ID != running || !reflect.DeepEqual(jobs[0].Parameters, []interface{}{"two"}) {
+		t.Fatalf("expected the interrupted job %d to run again, got %v", running, jobs)
+	}
+
+	id, err := c.pushJobTo("default", "survive", []interface{}{}, time.Now())
+	expectSuccess(t, err)
+	if id <= scheduled {
+		t.Errorf("expected a fresh job id after %d, got %d", scheduled, id)
+	}
+}
+
+func TestMemoryLogSurvivesFailedCompactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kigotest")
+	expectSuccess(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jobs.log")
+
+	store, err := NewMemoryStore(MemoryOptions{LogPath: path})
+	expectSuccess(t, err)
+	c := NewConnection(store)
+
+	_, err = c.pushJobTo("default", "compact", []interface{}{}, time.Now())
+	expectSuccess(t, err)
+
+	// The temporary file can't be created where there's a directory
+	expectSuccess(t, os.Mkdir(path+".tmp", 0700))
+	if err := store.Clear(); err == nil {
+		t.Fatal("expected the compaction to fail")
+	}
+	expectSuccess(t, os.Remove(path+".tmp"))
+
+	id, err := c.pushJobTo("default", "compact", []interface{}{}, time.Now())
+	expectSuccess(t, err)
+	expectSuccess(t, c.Close())
+
+	if _, err := c.pushJobTo("default", "compact", []interface{}{}, time.Now()); err != ErrMemoryLogClosed {
+		t.Errorf("expected %v pushing after closing, got %v", ErrMemoryLogClosed, err)
+	}
+
+	store, err = NewMemoryStore(MemoryOptions{LogPath: path})
+	expectSuccess(t, err)
+	defer store.Close()
+
+	jobs := store.Jobs()
+	if len(jobs) != 2 || jobs[1].ID != id {
+		t.Errorf("expected the job pushed after the failed compaction to be logged, got %v", jobs)
+	}
+}
+
+func TestMemoryConnectionsShareLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kigotest")
+	expectSuccess(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jobs.log")
+
+	first, err := Connect(memoryScheme + path)
+	expectSuccess(t, err)
+
+	second, err := ConnectMemory(MemoryOptions{LogPath: filepath.Join(dir, ".", "jobs.log")})
+	expectSuccess(t, err)
+
+	id, err := first.pushJobTo("default", "share", []interface{}{}, time.Now())
+	expectSuccess(t, err)
+
+	if _, err := second.fetchJobOutcome(id); err != nil {
+		t.Fatalf("expected connections to the same log to share jobs, got %v", err)
+	}
+
+	// The log stays open until both connections are closed
+	expectSuccess(t, first.Close())
+	expectSuccess(t, first.Close())
+
+	other, err := second.pushJobTo("default", "share", []interface{}{}, time.Now())
+	expectSuccess(t, err)
+	expectSuccess(t, second.Close())
+
+	reopened, err := Connect(memoryScheme + path)
+	expectSuccess(t, err)
+	defer reopened.Close()
+
+	for _, id := range []uint{id, other} {
+		outcome, err := reopened.fetchJobOutcome(id)
+		expectSuccess(t, err)
+		if outcome.State != JobEnqueued {
+			t.Errorf("expected job %d to be replayed from the log, got %v", id, outcome)
+		}
+	}
+}
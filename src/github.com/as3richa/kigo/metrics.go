@@ -0,0 +1,197 @@
+package kigo
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var jobDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+var claimDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type metricLabels struct {
+	task  string
+	queue string
+}
+
+func (l metricLabels) String() string {
+	return fmt.Sprintf(`task="%s",queue="%s"`, escapeLabelValue(l.task), escapeLabelValue(l.queue))
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, separator, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, separator, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// workerMetrics accumulates a worker's metrics from its events, and renders
+// them in the Prometheus text exposition format.
+type workerMetrics struct {
+	sync.Mutex
+
+	processed    map[metricLabels]uint64
+	failed       map[metricLabels]uint64
+	retried      map[metricLabels]uint64
+	jobDurations map[metricLabels]*histogram
+
+	claimDurations    *histogram
+	heartbeatFailures uint64
+}
+
+func newWorkerMetrics() *workerMetrics {
+	return &workerMetrics{
+		processed:      map[metricLabels]uint64{},
+		failed:         map[metricLabels]uint64{},
+		retried:        map[metricLabels]uint64{},
+		jobDurations:   map[metricLabels]*histogram{},
+		claimDurations: newHistogram(claimDurationBuckets),
+	}
+}
+
+// listener counts the worker's own events, and retries made through its bus,
+// which aren't made by any worker (e.g. through a webface in the same
+// process).
+func (m *workerMetrics) listener(workerID uint) Listener {
+	return func(event Event) {
+		if event.WorkerID != workerID && event.Kind != JobRetriedEvent {
+			return
+		}
+
+		m.Lock()
+		defer m.Unlock()
+
+		switch event.Kind {
+		case JobRetriedEvent:
+			m.retried[metricLabels{task: event.Job.TaskName, queue: event.Job.QueueName}]++
+		case JobFinishedEvent, JobFailedEvent:
+			labels := metricLabels{task: event.Job.TaskName, queue: event.Job.QueueName}
+
+			if event.Kind == JobFinishedEvent {
+				m.processed[labels]++
+			} else {
+				m.failed[labels]++
+			}
+
+			if _, ok := m.jobDurations[labels]; !ok {
+				m.jobDurations[labels] = newHistogram(jobDurationBuckets)
+			}
+			m.jobDurations[labels].observe(event.Duration.Seconds())
+		case WorkerHeartbeatEvent:
+			if event.Error != nil {
+				m.heartbeatFailures++
+			}
+		}
+	}
+}
+
+func (m *workerMetrics) observeClaim(duration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.claimDurations.observe(duration.Seconds())
+}
+
+func (m *workerMetrics) write(w io.Writer, busySlots uint, totalSlots uint) {
+	m.Lock()
+	defer m.Unlock()
+
+	writeCounters(w, "kigo_jobs_processed_total", "Jobs which finished successfully.", m.processed)
+	writeCounters(w, "kigo_jobs_failed_total", "Jobs which failed.", m.failed)
+	writeCounters(w, "kigo_jobs_retried_total", "Failed jobs which were retried.", m.retried)
+
+	fmt.Fprintf(w, "# HELP kigo_job_duration_seconds Job execution time.\n")
+	fmt.Fprintf(w, "# TYPE kigo_job_duration_seconds histogram\n")
+	for _, labels := range sortedLabels(m.jobDurations) {
+		m.jobDurations[labels].write(w, "kigo_job_duration_seconds", labels.String())
+	}
+
+	fmt.Fprintf(w, "# HELP kigo_claim_duration_seconds Time taken to claim a batch of jobs.\n")
+	fmt.Fprintf(w, "# TYPE kigo_claim_duration_seconds histogram\n")
+	m.claimDurations.write(w, "kigo_claim_duration_seconds", "")
+
+	fmt.Fprintf(w, "# HELP kigo_heartbeat_failures_total Worker heartbeats which failed.\n")
+	fmt.Fprintf(w, "# TYPE kigo_heartbeat_failures_total counter\n")
+	fmt.Fprintf(w, "kigo_heartbeat_failures_total %d\n", m.heartbeatFailures)
+
+	fmt.Fprintf(w, "# HELP kigo_worker_busy_slots Slots currently running a job.\n")
+	fmt.Fprintf(w, "# TYPE kigo_worker_busy_slots gauge\n")
+	fmt.Fprintf(w, "kigo_worker_busy_slots %d\n", busySlots)
+
+	fmt.Fprintf(w, "# HELP kigo_worker_slots Total slots, i.e. the worker's concurrency.\n")
+	fmt.Fprintf(w, "# TYPE kigo_worker_slots gauge\n")
+	fmt.Fprintf(w, "kigo_worker_slots %d\n", totalSlots)
+}
+
+func writeCounters(w io.Writer, name string, help string, counters map[metricLabels]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+	labelSets := make([]metricLabels, 0, len(counters))
+	for labels := range counters {
+		labelSets = append(labelSets, labels)
+	}
+	sortLabels(labelSets)
+
+	for _, labels := range labelSets {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, counters[labels])
+	}
+}
+
+func sortedLabels(histograms map[metricLabels]*histogram) []metricLabels {
+	labelSets := make([]metricLabels, 0, len(histograms))
+	for labels := range histograms {
+		labelSets = append(labelSets, labels)
+	}
+	sortLabels(labelSets)
+	return labelSets
+}
+
+func sortLabels(labelSets []metricLabels) {
+	sort.Slice(labelSets, func(i, j int) bool {
+		if labelSets[i].task != labelSets[j].task {
+			return labelSets[i].task < labelSets[j].task
+		}
+		return labelSets[i].queue < labelSets[j].queue
+	})
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
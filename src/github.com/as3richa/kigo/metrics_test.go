@@ -0,0 +1,52 @@
+package kigo
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerMetricsExposition(t *testing.T) {
+	metrics := newWorkerMetrics()
+	listener := metrics.listener(1)
+
+	job := &Job{TaskName: "Export\"Report", QueueName: "default"}
+	listener(Event{Kind: JobFinishedEvent, WorkerID: 1, Job: job, Duration: 2 * time.Second})
+	listener(Event{Kind: JobFinishedEvent, WorkerID: 1, Job: job, Duration: 20 * time.Millisecond})
+	listener(Event{Kind: JobFailedEvent, WorkerID: 1, Job: job, Duration: time.Second})
+	listener(Event{Kind: JobFinishedEvent, WorkerID: 2, Job: job, Duration: time.Second})
+	listener(Event{Kind: JobRetriedEvent, Job: job})
+	listener(Event{Kind: JobRetriedEvent, Job: &Job{TaskName: "Sync", QueueName: "beta"}})
+	listener(Event{Kind: WorkerHeartbeatEvent, WorkerID: 1, Error: errors.New("augh")})
+	listener(Event{Kind: WorkerHeartbeatEvent, WorkerID: 1})
+	metrics.observeClaim(3 * time.Millisecond)
+
+	buffer := bytes.Buffer{}
+	metrics.write(&buffer, 3, 5)
+	output := buffer.String()
+
+	expected := []string{
+		`kigo_jobs_processed_total{task="Export\"Report",queue="default"} 2`,
+		`kigo_jobs_failed_total{task="Export\"Report",queue="default"} 1`,
+		`kigo_jobs_retried_total{task="Export\"Report",queue="default"} 1`,
+		`kigo_jobs_retried_total{task="Sync",queue="beta"} 1`,
+		`kigo_job_duration_seconds_bucket{task="Export\"Report",queue="default",le="0.025"} 1`,
+		`kigo_job_duration_seconds_bucket{task="Export\"Report",queue="default",le="1"} 2`,
+		`kigo_job_duration_seconds_bucket{task="Export\"Report",queue="default",le="+Inf"} 3`,
+		`kigo_job_duration_seconds_count{task="Export\"Report",queue="default"} 3`,
+		`kigo_claim_duration_seconds_bucket{le="0.0025"} 0`,
+		`kigo_claim_duration_seconds_bucket{le="0.005"} 1`,
+		`kigo_claim_duration_seconds_count 1`,
+		`kigo_heartbeat_failures_total 1`,
+		`kigo_worker_busy_slots 3`,
+		`kigo_worker_slots 5`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("expected output to contain %q; got:\n%s", line, output)
+		}
+	}
+}
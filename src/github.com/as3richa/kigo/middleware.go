@@ -0,0 +1,65 @@
+package kigo
+
+import "time"
+
+// PendingJob is a job on its way to being enqueued, as seen by client
+// middleware. Middleware may modify it before passing it along.
+type PendingJob struct {
+	TaskName   string
+	Parameters []interface{}
+	QueueName  string
+	StartAt    time.Time
+}
+
+// ClientMiddleware wraps the enqueueing of jobs. It must call next to let the
+// job through, and may instead return an error to refuse it.
+type ClientMiddleware func(job *PendingJob, next func(*PendingJob) (uint, error)) (uint, error)
+
+// ServerMiddleware wraps the execution of tasks on the worker. next runs the
+// rest of the chain and ultimately the task itself, returning the task's
+// result (nil if it has none) and error; middleware may replace either.
+type ServerMiddleware func(context *TaskContext, next func() (interface{}, error)) (interface{}, error)
+
+var clientMiddleware []ClientMiddleware
+var serverMiddleware []ServerMiddleware
+
+// UseClientMiddleware appends to the client middleware chain. Middleware
+// registered first runs outermost.
+func UseClientMiddleware(middleware ...ClientMiddleware) {
+	clientMiddleware = append(clientMiddleware, middleware...)
+}
+
+// UseServerMiddleware appends to the server middleware chain. Middleware
+// registered first runs outermost. Panics in tasks propagate through the
+// chain, so middleware may recover from them itself.
+func UseServerMiddleware(middleware ...ServerMiddleware) {
+	serverMiddleware = append(serverMiddleware, middleware...)
+}
+
+func runClientMiddleware(job *PendingJob, push func(*PendingJob) (uint, error)) (uint, error) {
+	chain := clientMiddleware
+
+	var next func(int, *PendingJob) (uint, error)
+	next = func(i int, job *PendingJob) (uint, error) {
+		if i == len(chain) {
+			return push(job)
+		}
+		return chain[i](job, func(job *PendingJob) (uint, error) { return next(i+1, job) })
+	}
+
+	return next(0, job)
+}
+
+func runServerMiddleware(context *TaskContext, invoke func() (interface{}, error)) (interface{}, error) {
+	chain := serverMiddleware
+
+	var next func(int) (interface{}, error)
+	next = func(i int) (interface{}, error) {
+		if i == len(chain) {
+			return invoke()
+		}
+		return chain[i](context, func() (interface{}, error) { return next(i + 1) })
+	}
+
+	return next(0)
+}
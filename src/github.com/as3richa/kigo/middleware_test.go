@@ -0,0 +1,97 @@
+package kigo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func withMiddleware(client []ClientMiddleware, server []ServerMiddleware, callback func()) {
+	savedClient, savedServer := clientMiddleware, serverMiddleware
+	defer func() { clientMiddleware, serverMiddleware = savedClient, savedServer }()
+
+	clientMiddleware, serverMiddleware = client, server
+	callback()
+}
+
+func TestClientMiddlewareRunsInOrderAndCanRefuse(t *testing.T) {
+	var order []string
+	refusal := errors.New("refused")
+
+	tag := func(name string) ClientMiddleware {
+		return func(job *PendingJob, next func(*PendingJob) (uint, error)) (uint, error) {
+			order = append(order, name)
+			job.Parameters = append(job.Parameters, name)
+			return next(job)
+		}
+	}
+
+	refuseLow := func(job *PendingJob, next func(*PendingJob) (uint, error)) (uint, error) {
+		if job.QueueName == "low" {
+			return 0, refusal
+		}
+		return next(job)
+	}
+
+	withMiddleware([]ClientMiddleware{tag("outer"), refuseLow, tag("inner")}, nil, func() {
+		var pushed *PendingJob
+		push := func(job *PendingJob) (uint, error) {
+			pushed = job
+			return 1, nil
+		}
+
+		id, err := runClientMiddleware(&PendingJob{TaskName: "a", QueueName: "default"}, push)
+		expectSuccess(t, err)
+
+		if id != 1 || fmt.Sprint(pushed.Parameters) != "[outer inner]" || fmt.Sprint(order) != "[outer inner]" {
+			t.Errorf("unexpected id %v, parameters %v, order %v", id, pushed.Parameters, order)
+		}
+
+		pushed = nil
+		if _, err := runClientMiddleware(&PendingJob{TaskName: "a", QueueName: "low"}, push); err != refusal || pushed != nil {
+			t.Errorf("expected job to be refused, got %v and %v", err, pushed)
+		}
+	})
+}
+
+func TestServerMiddlewareCanChangeOutcome(t *testing.T) {
+	RegisterTask("testMiddlewareFail", func() error { return errors.New("augh") })
+	RegisterTask("testMiddlewarePanic", func() error { panic("augh") })
+
+	forgive := func(context *TaskContext, next func() (interface{}, error)) (interface{}, error) {
+		if _, err := next(); err != nil {
+			return "forgiven " + context.Job.TaskName, nil
+		}
+		return nil, nil
+	}
+
+	recoverer := func(context *TaskContext, next func() (interface{}, error)) (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result, err = nil, fmt.Errorf("recovered: %v", r)
+			}
+		}()
+		return next()
+	}
+
+	withMiddleware(nil, []ServerMiddleware{forgive, recoverer}, func() {
+		outcome := performTaskSync(t, "testMiddlewareFail", nil)
+		expectSuccess(t, outcome.err)
+
+		var result string
+		expectSuccess(t, decodeValue(outcome.result, &result))
+		if result != "forgiven testMiddlewareFail" {
+			t.Errorf("expected %v, got %v", "forgiven testMiddlewareFail", result)
+		}
+
+		outcome = performTaskSync(t, "testMiddlewarePanic", nil)
+		expectSuccess(t, outcome.err)
+	})
+
+	withMiddleware(nil, []ServerMiddleware{recoverer}, func() {
+		outcome := performTaskSync(t, "testMiddlewarePanic", nil)
+		if outcome.err == nil || outcome.err.Error() != "recovered: augh" {
+			t.Errorf("expected panic to be recovered by middleware, got %v", outcome.err)
+		}
+	})
+}
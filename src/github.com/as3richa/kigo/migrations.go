@@ -0,0 +1,811 @@
+package kigo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LatestSchemaVersion is the version of the newest migration.
+const LatestSchemaVersion = 9
+
+const schemaMigrationsTable = "kigo_schema_migrations"
+
+// Concurrent migrations on Postgres and MySQL are serialized by an advisory
+// lock, named by this key.
+const migrationLockKey = 0x6b69676f
+const migrationLockName = "kigo_schema_migrations"
+const migrationLockTimeoutSeconds = 300
+
+// migration is a numbered schema change. Migrations are written as SQL, per
+// dialect, rather than with gorm, so that they can rename and drop columns,
+// backfill data, and be printed for review.
+type migration struct {
+	version uint
+	name    string
+	up      func(d schemaDialect) []string
+	down    func(d schemaDialect) []string
+
+	// creates names what the migration adds, so that schemas created before
+	// versioned migrations can be recognized
+	creates schemaObjects
+}
+
+// schemaObjects names tables, columns (as "table.column") and indexes.
+type schemaObjects struct {
+	tables  []string
+	columns []string
+	indexes []string
+}
+
+func (o schemaObjects) empty() bool {
+	return len(o.tables) == 0 && len(o.columns) == 0 && len(o.indexes) == 0
+}
+
+// Version 1 is the schema of the first release: workers, queues and jobs.
+// Later versions follow the features which needed schema changes.
+var migrations = []migration{
+	{1, "create_jobs", createJobs, dropJobs, schemaObjects{
+		tables: []string{"workers", "queues", "worker_queues", "jobs"},
+		columns: []string{
+			"workers.name", "workers.concurrency", "workers.started_at", "workers.heartbeat_at", "workers.stopped_at",
+			"worker_queues.worker_model_id", "worker_queues.queue_model_name",
+			"jobs.queue_name", "jobs.worker_id", "jobs.worker_thread_id", "jobs.task_name", "jobs.param_blob", "jobs.state",
+			"jobs.enqueued_at", "jobs.start_at", "jobs.started_at", "jobs.finished_at", "jobs.error",
+		},
+		indexes: []string{"jobs_queue_name_and_state_and_start_at_and_enqueued_at", "jobs_started_at"},
+	}},
+	{2, "add_job_results", addJobResults, dropJobResults, schemaObjects{
+		columns: []string{"jobs.result_blob"},
+	}},
+	{3, "add_job_progress", addJobProgress, dropJobProgress, schemaObjects{
+		columns: []string{"jobs.progress_percent", "jobs.progress_message", "jobs.progress_at"},
+	}},
+	{4, "create_job_logs", createJobLogs, dropJobLogs, schemaObjects{
+		tables:  []string{"job_logs"},
+		indexes: []string{"job_logs_job_id"},
+	}},
+	{5, "index_jobs_finished_at", indexJobsFinishedAt, unindexJobsFinishedAt, schemaObjects{
+		indexes: []string{"jobs_finished_at"},
+	}},
+	{6, "create_job_stats", createJobStats, dropJobStats, schemaObjects{
+		tables:  []string{"job_stats"},
+		indexes: []string{"job_stats_bucket"},
+	}},
+	{7, "create_job_history_and_leases", createJobHistoryAndLeases, dropJobHistoryAndLeases, schemaObjects{
+		tables: []string{"job_history", "leases"},
+	}},
+	{8, "create_job_durations", createJobDurations, dropJobDurations, schemaObjects{
+		tables:  []string{"job_durations"},
+		indexes: []string{"job_durations_bucket"},
+	}},
+	{9, "widen_text_columns", widenTextColumns, keepTextColumns, schemaObjects{}},
+}
+
+// schemaDialect holds the differences between databases which matter to
+// migrations.
+type schemaDialect struct {
+	name string
+
+	serial    string
+	integer   string
+	bigint    string
+	float     string
+	timestamp string
+	varchar   string
+	text      string
+	blob      string
+
+	// MySQL can only index a prefix of a text column, given by textKey
+	textKey string
+
+	// SQLite only enforces foreign keys when a pragma enables them, and can't
+	// add them to existing tables, so migrations use triggers there instead
+	foreignKeys bool
+
+	// Migrations run in a transaction where DDL is transactional. MySQL
+	// commits after each DDL statement, so a failed migration may leave
+	// some of its statements applied.
+	transactionalDDL bool
+
+	tableExists  string
+	columnExists string
+	indexExists  string
+}
+
+var schemaDialects = map[string]schemaDialect{
+	"postgres": {
+		name:             "postgres",
+		serial:           "serial PRIMARY KEY",
+		integer:          "integer",
+		bigint:           "bigint",
+		float:            "numeric",
+		timestamp:        "timestamp with time zone",
+		varchar:          "varchar(255)",
+		text:             "text",
+		blob:             "bytea",
+		foreignKeys:      true,
+		transactionalDDL: true,
+		tableExists:      "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = '%s'",
+		columnExists:     "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = '%s' AND column_name = '%s'",
+		indexExists:      "SELECT COUNT(*) FROM pg_indexes WHERE schemaname = CURRENT_SCHEMA() AND indexname = '%s'",
+	},
+	"mysql": {
+		name:         "mysql",
+		serial:       "int unsigned AUTO_INCREMENT PRIMARY KEY",
+		integer:      "int unsigned",
+		bigint:       "bigint unsigned",
+		float:        "double",
+		timestamp:    "DATETIME(6)",
+		varchar:      "varchar(255)",
+		text:         "longtext",
+		blob:         "longblob",
+		textKey:      "(255)",
+		foreignKeys:  true,
+		tableExists:  "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = '%s'",
+		columnExists: "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = '%s' AND column_name = '%s'",
+		indexExists:  "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND index_name = '%s'",
+	},
+	"sqlite3": {
+		name:             "sqlite3",
+		serial:           "integer PRIMARY KEY AUTOINCREMENT",
+		integer:          "integer",
+		bigint:           "integer",
+		float:            "real",
+		timestamp:        "datetime",
+		varchar:          "varchar(255)",
+		text:             "text",
+		blob:             "blob",
+		transactionalDDL: true,
+		tableExists:      "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = '%s'",
+		columnExists:     "SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = '%s'",
+		indexExists:      "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = '%s'",
+	},
+}
+
+// expand replaces the placeholders {serial}, {integer}, {bigint}, {float},
+// {timestamp}, {varchar}, {text} and {blob} with the dialect's types, and
+// {textkey}, which follows a text column in an index, with the prefix of it
+// to index.
+func (d schemaDialect) expand(sql string) string {
+	return strings.NewReplacer(
+		"{textkey}", d.textKey,
+		"{serial}", d.serial,
+		"{integer}", d.integer,
+		"{bigint}", d.bigint,
+		"{float}", d.float,
+		"{timestamp}", d.timestamp,
+		"{varchar}", d.varchar,
+		"{text}", d.text,
+		"{blob}", d.blob,
+	).Replace(sql)
+}
+
+func (d schemaDialect) columns(definitions ...string) string {
+	return "(\n  " + d.expand(strings.Join(definitions, ",\n  ")) + "\n)"
+}
+
+func (d schemaDialect) createTable(name string, definitions ...string) string {
+	return "CREATE TABLE " + name + " " + d.columns(definitions...)
+}
+
+func (d schemaDialect) createMigrationsTable() string {
+	return "CREATE TABLE IF NOT EXISTS " + schemaMigrationsTable + " " + d.columns(
+		"version {integer} NOT NULL PRIMARY KEY",
+		"name {varchar} NOT NULL",
+		"applied_at {timestamp} NOT NULL",
+	)
+}
+
+// jobColumns are the columns of the jobs table at version 1.
+var jobColumns = []string{
+	"id {serial}",
+	"queue_name {varchar}",
+	"worker_id {integer}",
+	"worker_thread_id {integer}",
+	"task_name {text}",
+	"param_blob {blob}",
+	"state {integer}",
+	"enqueued_at {timestamp}",
+	"start_at {timestamp}",
+	"started_at {timestamp}",
+	"finished_at {timestamp}",
+	"error {text}",
+}
+
+var jobIndexes = []string{
+	"CREATE INDEX jobs_queue_name_and_state_and_start_at_and_enqueued_at ON jobs (queue_name, state, start_at, enqueued_at)",
+	"CREATE INDEX jobs_started_at ON jobs (started_at)",
+}
+
+func createJobs(d schemaDialect) []string {
+	columns := jobColumns
+	if d.foreignKeys {
+		columns = append(columns[:len(columns):len(columns)], "CONSTRAINT jobs_queue_name_queues_name_foreign FOREIGN KEY (queue_name) REFERENCES queues (name) ON DELETE RESTRICT ON UPDATE RESTRICT")
+	}
+
+	statements := []string{
+		d.createTable("workers",
+			"id {serial}",
+			"name {text}",
+			"concurrency {integer}",
+			"started_at {timestamp}",
+			"heartbeat_at {timestamp}",
+			"stopped_at {timestamp}",
+		),
+		d.createTable("queues",
+			"name {varchar} NOT NULL PRIMARY KEY",
+		),
+		d.createTable("worker_queues",
+			"worker_model_id {integer} NOT NULL",
+			"queue_model_name {varchar} NOT NULL",
+			"PRIMARY KEY (worker_model_id, queue_model_name)",
+		),
+		d.createTable("jobs", columns...),
+	}
+
+	return append(statements, jobIndexes...)
+}
+
+func dropJobs(d schemaDialect) []string {
+	return []string{
+		"DROP TABLE jobs",
+		"DROP TABLE worker_queues",
+		"DROP TABLE queues",
+		"DROP TABLE workers",
+	}
+}
+
+func addJobResults(d schemaDialect) []string {
+	return []string{d.expand("ALTER TABLE jobs ADD COLUMN result_blob {blob}")}
+}
+
+func dropJobResults(d schemaDialect) []string {
+	return d.dropJobColumns(jobColumns, "result_blob")
+}
+
+var jobProgressColumns = []string{
+	"progress_percent {float}",
+	"progress_message {text}",
+	"progress_at {timestamp}",
+}
+
+func addJobProgress(d schemaDialect) []string {
+	statements := make([]string, len(jobProgressColumns))
+	for i, column := range jobProgressColumns {
+		statements[i] = d.expand("ALTER TABLE jobs ADD COLUMN " + column)
+	}
+	return statements
+}
+
+func dropJobProgress(d schemaDialect) []string {
+	return d.dropJobColumns(append(jobColumns[:len(jobColumns):len(jobColumns)], "result_blob {blob}"),
+		"progress_percent", "progress_message", "progress_at")
+}
+
+// dropJobColumns drops columns from the jobs table, which is left with the
+// given column definitions. SQLite can't drop columns, so there the table is
+// rebuilt without them. Only migrations which run before job_logs and the
+// finished_at index exist drop job columns, so only version 1's indexes are
+// recreated.
+func (d schemaDialect) dropJobColumns(remaining []string, dropped ...string) []string {
+	if d.name != "sqlite3" {
+		statements := make([]string, len(dropped))
+		for i, column := range dropped {
+			statements[i] = "ALTER TABLE jobs DROP COLUMN " + column
+		}
+		return statements
+	}
+
+	names := make([]string, len(remaining))
+	for i, definition := range remaining {
+		names[i] = strings.Fields(definition)[0]
+	}
+	columnList := strings.Join(names, ", ")
+
+	statements := []string{
+		d.createTable("jobs_rebuild", remaining...),
+		"INSERT INTO jobs_rebuild (" + columnList + ") SELECT " + columnList + " FROM jobs",
+		"DROP TABLE jobs",
+		"ALTER TABLE jobs_rebuild RENAME TO jobs",
+	}
+	return append(statements, jobIndexes...)
+}
+
+func createJobLogs(d schemaDialect) []string {
+	columns := []string{
+		"id {serial}",
+		"job_id {integer}",
+		"level {text}",
+		"message {text}",
+		"fields {text}",
+		"logged_at {timestamp}",
+	}
+
+	if d.foreignKeys {
+		columns = append(columns, "CONSTRAINT job_logs_job_id_jobs_id_foreign FOREIGN KEY (job_id) REFERENCES jobs (id) ON DELETE CASCADE ON UPDATE RESTRICT")
+	}
+
+	statements := []string{
+		d.createTable("job_logs", columns...),
+		"CREATE INDEX job_logs_job_id ON job_logs (job_id)",
+	}
+
+	if !d.foreignKeys {
+		statements = append(statements,
+			"CREATE TRIGGER job_logs_delete_with_job AFTER DELETE ON jobs\nBEGIN DELETE FROM job_logs WHERE job_id = OLD.id; END",
+		)
+	}
+
+	return statements
+}
+
+func dropJobLogs(d schemaDialect) []string {
+	if !d.foreignKeys {
+		return []string{"DROP TRIGGER job_logs_delete_with_job", "DROP TABLE job_logs"}
+	}
+	return []string{"DROP TABLE job_logs"}
+}
+
+func indexJobsFinishedAt(d schemaDialect) []string {
+	return []string{"CREATE INDEX jobs_finished_at ON jobs (finished_at)"}
+}
+
+func unindexJobsFinishedAt(d schemaDialect) []string {
+	if d.name == "mysql" {
+		return []string{"DROP INDEX jobs_finished_at ON jobs"}
+	}
+	return []string{"DROP INDEX jobs_finished_at"}
+}
+
+func createJobStats(d schemaDialect) []string {
+	return []string{
+		d.createTable("job_stats",
+			"id {serial}",
+			"resolution {integer}",
+			"bucket_start {timestamp}",
+			"queue_name {varchar}",
+			"task_name {text}",
+			"processed {integer}",
+			"failed {integer}",
+			"retried {integer}",
+		),
+		d.expand("CREATE UNIQUE INDEX job_stats_bucket ON job_stats (resolution, bucket_start, queue_name, task_name{textkey})"),
+	}
+}
+
+func dropJobStats(d schemaDialect) []string {
+	return []string{"DROP TABLE job_stats"}
+}
+
+func createJobHistoryAndLeases(d schemaDialect) []string {
+	return []string{
+		d.createTable("job_history",
+			"id {integer} NOT NULL PRIMARY KEY",
+			"queue_name {text}",
+			"worker_id {integer}",
+			"task_name {text}",
+			"param_blob {blob}",
+			"result_blob {blob}",
+			"state {integer}",
+			"enqueued_at {timestamp}",
+			"start_at {timestamp}",
+			"started_at {timestamp}",
+			"finished_at {timestamp}",
+			"error {text}",
+			"archived_at {timestamp}",
+		),
+		d.createTable("leases",
+			"name {varchar} NOT NULL PRIMARY KEY",
+			"worker_id {integer}",
+			"expires_at {timestamp}",
+		),
+	}
+}
+
+func dropJobHistoryAndLeases(d schemaDialect) []string {
+	return []string{"DROP TABLE leases", "DROP TABLE job_history"}
+}
+
+func createJobDurations(d schemaDialect) []string {
+	return []string{
+		d.createTable("job_durations",
+			"id {serial}",
+			"resolution {integer}",
+			"bucket_start {timestamp}",
+			"task_name {text}",
+			"bin {integer}",
+			"completed {integer}",
+			"total_us {bigint}",
+			"max_us {bigint}",
+		),
+		d.expand("CREATE UNIQUE INDEX job_durations_bucket ON job_durations (resolution, bucket_start, task_name{textkey}, bin)"),
+	}
+}
+
+func dropJobDurations(d schemaDialect) []string {
+	return []string{"DROP TABLE job_durations"}
+}
+
+// MySQL schemas set up by gorm before versioned migrations made unsized string
+// columns varchar(255), which truncates long errors, log lines and progress
+// messages. Elsewhere those columns are already unbounded.
+func widenTextColumns(d schemaDialect) []string {
+	if d.name != "mysql" {
+		return nil
+	}
+
+	return []string{
+		"ALTER TABLE workers MODIFY name longtext",
+		"ALTER TABLE jobs MODIFY task_name longtext, MODIFY error longtext, MODIFY progress_message longtext",
+		"ALTER TABLE job_logs MODIFY level longtext, MODIFY message longtext, MODIFY fields longtext",
+		"ALTER TABLE job_history MODIFY task_name longtext, MODIFY error longtext",
+	}
+}
+
+// keepTextColumns leaves the columns wide, since narrowing them could truncate
+// their contents.
+func keepTextColumns(d schemaDialect) []string {
+	return nil
+}
+
+// migrationSteps returns the statements which take the schema from one version
+// to another, with the statements which record each step, grouped by step.
+func migrationSteps(d schemaDialect, from uint, to uint) [][]string {
+	var steps [][]string
+
+	for version := from + 1; version <= to; version++ {
+		m := migrations[version-1]
+		steps = append(steps, append(m.up(d), m.record()))
+	}
+
+	for version := from; version > to; version-- {
+		m := migrations[version-1]
+		steps = append(steps, append(m.down(d), m.unrecord()))
+	}
+
+	return steps
+}
+
+func (m migration) record() string {
+	return fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (%d, '%s', CURRENT_TIMESTAMP)", schemaMigrationsTable, m.version, m.name)
+}
+
+func (m migration) unrecord() string {
+	return fmt.Sprintf("DELETE FROM %s WHERE version = %d", schemaMigrationsTable, m.version)
+}
+
+func (c Connection) schemaDialect() (schemaDialect, error) {
+	if err := c.requireSQL(); err != nil {
+		return schemaDialect{}, err
+	}
+
+	d, ok := schemaDialects[c.dialect()]
+	if !ok {
+		return schemaDialect{}, fmt.Errorf("unsupported dialect %q", c.dialect())
+	}
+	return d, nil
+}
+
+func checkSchemaVersion(version uint) error {
+	if version > LatestSchemaVersion {
+		return fmt.Errorf("no such schema version %d; the latest is %d", version, LatestSchemaVersion)
+	}
+	return nil
+}
+
+// Migrate brings the database's schema up to LatestSchemaVersion. Stores which
+// aren't backed by SQL have nothing to migrate.
+func (c Connection) Migrate() error {
+	if c.db == nil {
+		return nil
+	}
+	return c.MigrateTo(LatestSchemaVersion)
+}
+
+// MigrateTo applies the up or down migrations which take the database's schema
+// to the given version, recording each in the kigo_schema_migrations table.
+// Concurrent migrations wait for each other. Databases which were set up by
+// versions of kigo before versioned migrations are inspected to find their
+// version, which is recorded before migrating further.
+func (c Connection) MigrateTo(version uint) error {
+	d, err := c.schemaDialect()
+	if err != nil {
+		return err
+	}
+
+	if err := checkSchemaVersion(version); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	// The lock is held by a session, so everything happens on one connection
+	conn, err := c.db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch d.name {
+	case "postgres":
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SELECT pg_advisory_lock(%d)", migrationLockKey)); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, fmt.Sprintf("SELECT pg_advisory_unlock(%d)", migrationLockKey))
+	case "mysql":
+		var acquired sql.NullInt64
+		query := fmt.Sprintf("SELECT GET_LOCK('%s', %d)", migrationLockName, migrationLockTimeoutSeconds)
+		if err := conn.QueryRowContext(ctx, query).Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired.Int64 != 1 {
+			return fmt.Errorf("timed out waiting for the %s lock", migrationLockName)
+		}
+		defer conn.ExecContext(ctx, fmt.Sprintf("SELECT RELEASE_LOCK('%s')", migrationLockName))
+	case "sqlite3":
+		// SQLite has no advisory locks, but its DDL is transactional and
+		// transactions take the write lock up front, so the whole migration
+		// runs in one transaction instead
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := migrate(ctx, tx, d, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+
+	return migrate(ctx, conn, d, version)
+}
+
+// sqlExecutor is satisfied by both *sql.Conn and *sql.Tx.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func migrate(ctx context.Context, executor sqlExecutor, d schemaDialect, version uint) error {
+	if _, err := executor.ExecContext(ctx, d.createMigrationsTable()); err != nil {
+		return err
+	}
+
+	current, unrecorded, err := readSchemaVersion(ctx, executor, d)
+	if err != nil {
+		return err
+	}
+
+	if unrecorded {
+		if err := execStatements(ctx, executor, recordAdoption(current)); err != nil {
+			return err
+		}
+	}
+
+	for _, step := range migrationSteps(d, current, version) {
+		if err := execStep(ctx, executor, d, step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordAdoption returns the statements which record that a schema which
+// predates versioned migrations is at the given version.
+func recordAdoption(version uint) []string {
+	records := make([]string, version)
+	for i := range records {
+		records[i] = migrations[i].record()
+	}
+	return records
+}
+
+// execStep runs a migration's statements in a transaction, where that's
+// useful and the executor isn't already one.
+func execStep(ctx context.Context, executor sqlExecutor, d schemaDialect, statements []string) error {
+	conn, ok := executor.(*sql.Conn)
+	if !ok || !d.transactionalDDL {
+		return execStatements(ctx, executor, statements)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := execStatements(ctx, tx, statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func execStatements(ctx context.Context, executor sqlExecutor, statements []string) error {
+	for _, statement := range statements {
+		if _, err := executor.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%v (in %q)", err, statement)
+		}
+	}
+	return nil
+}
+
+// readSchemaVersion returns the latest migration recorded, or if none is, the
+// version of a schema set up before versioned migrations, along with true.
+func readSchemaVersion(ctx context.Context, executor sqlExecutor, d schemaDialect) (uint, bool, error) {
+	recorded, err := d.exists(ctx, executor, d.tableExists, schemaMigrationsTable)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if recorded {
+		var version sql.NullInt64
+		if err := executor.QueryRowContext(ctx, "SELECT MAX(version) FROM "+schemaMigrationsTable).Scan(&version); err != nil {
+			return 0, false, err
+		}
+
+		if version.Valid {
+			return uint(version.Int64), false, nil
+		}
+	}
+
+	version, err := detectSchemaVersion(ctx, executor, d)
+	return version, version != 0, err
+}
+
+// detectSchemaVersion works out the version of a schema which predates
+// versioned migrations from the tables, columns and indexes it has. Such
+// schemas were kept up to date by adding whatever was missing, so the version
+// is that of the last migration whose objects are all present, up to the first
+// migration which changes rather than adds objects. A schema with
+// objects from a later migration as well, or with only part of version 1, was
+// changed by hand and is refused rather than guessed at.
+func detectSchemaVersion(ctx context.Context, executor sqlExecutor, d schemaDialect) (uint, error) {
+	if legacy, err := d.exists(ctx, executor, d.tableExists, "jobs"); err != nil || !legacy {
+		return 0, err
+	}
+
+	var version uint
+	var firstMissing string
+
+	for _, m := range migrations {
+		// A migration which only changes existing objects can't be detected;
+		// it's left pending, and must be safe to rerun
+		if m.creates.empty() && len(m.up(d)) != 0 {
+			break
+		}
+
+		present, missing, err := d.inspect(ctx, executor, m.creates)
+		if err != nil {
+			return 0, err
+		}
+
+		if firstMissing == "" && len(missing) == 0 {
+			version = m.version
+			continue
+		}
+
+		if firstMissing == "" {
+			firstMissing = missing[0]
+		}
+
+		if len(present) != 0 {
+			return 0, fmt.Errorf("can't recognize the existing schema, which has %s but not %s; bring it up to date by hand and record its version in %s", present[0], firstMissing, schemaMigrationsTable)
+		}
+	}
+
+	if version == 0 {
+		return 0, fmt.Errorf("can't recognize the existing schema, which has a jobs table but not %s", firstMissing)
+	}
+
+	return version, nil
+}
+
+// inspect sorts the objects into those which exist and those which don't.
+func (d schemaDialect) inspect(ctx context.Context, executor sqlExecutor, objects schemaObjects) ([]string, []string, error) {
+	var present, missing []string
+
+	check := func(description string, query string, args ...interface{}) error {
+		exists, err := d.exists(ctx, executor, query, args...)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			present = append(present, description)
+		} else {
+			missing = append(missing, description)
+		}
+		return nil
+	}
+
+	for _, table := range objects.tables {
+		if err := check("table "+table, d.tableExists, table); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, column := range objects.columns {
+		parts := strings.SplitN(column, ".", 2)
+		if err := check("column "+column, d.columnExists, parts[0], parts[1]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, index := range objects.indexes {
+		if err := check("index "+index, d.indexExists, index); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return present, missing, nil
+}
+
+func (d schemaDialect) exists(ctx context.Context, executor sqlExecutor, query string, args ...interface{}) (bool, error) {
+	var count int
+	err := executor.QueryRowContext(ctx, fmt.Sprintf(query, args...)).Scan(&count)
+	return count != 0, err
+}
+
+// SchemaVersion returns the version of the database's schema.
+func (c Connection) SchemaVersion() (uint, error) {
+	d, err := c.schemaDialect()
+	if err != nil {
+		return 0, err
+	}
+
+	version, _, err := readSchemaVersion(context.Background(), c.db.DB(), d)
+	return version, err
+}
+
+// MigrationSQL returns the statements which MigrateTo(version) would execute
+// against this database, without executing them, so that they can be reviewed
+// or applied by hand. Like MigrateTo, they record the version of a schema
+// which predates versioned migrations before migrating further.
+func (c Connection) MigrationSQL(version uint) ([]string, error) {
+	d, err := c.schemaDialect()
+	if err != nil {
+		return nil, err
+	}
+
+	current, unrecorded, err := readSchemaVersion(context.Background(), c.db.DB(), d)
+	if err != nil {
+		return nil, err
+	}
+
+	statements, err := MigrationSQL(d.name, current, version)
+	if err != nil || !unrecorded {
+		return statements, err
+	}
+
+	// The first statement creates the migrations table
+	adopted := append([]string{statements[0]}, recordAdoption(current)...)
+	return append(adopted, statements[1:]...), nil
+}
+
+// MigrationSQL returns the statements which migrate a schema from one version
+// to another on the given dialect ("postgres", "mysql" or "sqlite3"), without
+// needing a database.
+func MigrationSQL(dialect string, from uint, to uint) ([]string, error) {
+	d, ok := schemaDialects[dialect]
+	if !ok {
+		return nil, fmt.Errorf("unsupported dialect %q", dialect)
+	}
+
+	if err := checkSchemaVersion(from); err != nil {
+		return nil, err
+	}
+	if err := checkSchemaVersion(to); err != nil {
+		return nil, err
+	}
+
+	statements := []string{d.createMigrationsTable()}
+	for _, step := range migrationSteps(d, from, to) {
+		statements = append(statements, step...)
+	}
+	return statements, nil
+}
@@ -0,0 +1,198 @@
+package kigo
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	if len(migrations) != LatestSchemaVersion {
+		t.Fatalf("expected LatestSchemaVersion %d to match the %d migrations", LatestSchemaVersion, len(migrations))
+	}
+
+	for i, m := range migrations {
+		if m.version != uint(i+1) {
+			t.Errorf("expected migration %s to be version %d, got %d", m.name, i+1, m.version)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		version, err := c.SchemaVersion()
+		expectSuccess(t, err)
+		if version != LatestSchemaVersion {
+			t.Fatalf("expected version %d, got %d", LatestSchemaVersion, version)
+		}
+
+		expectSuccess(t, c.MigrateTo(0))
+
+		version, err = c.SchemaVersion()
+		expectSuccess(t, err)
+		if version != 0 || c.db.HasTable("jobs") {
+			t.Fatalf("expected an empty schema at version 0, got version %d", version)
+		}
+
+		// Concurrent migrations wait for each other rather than racing
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- c.Migrate()
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			expectSuccess(t, err)
+		}
+
+		version, err = c.SchemaVersion()
+		expectSuccess(t, err)
+		if version != LatestSchemaVersion {
+			t.Errorf("expected version %d, got %d", LatestSchemaVersion, version)
+		}
+
+		if err := c.MigrateTo(LatestSchemaVersion + 1); err == nil {
+			t.Error("expected an error migrating to a version which doesn't exist")
+		}
+	})
+}
+
+func TestMigrateAdoptsUnversionedSchemas(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		expectSuccess(t, c.db.DropTable(schemaMigrationsTable).Error)
+
+		version, err := c.SchemaVersion()
+		expectSuccess(t, err)
+		if version != LatestSchemaVersion {
+			t.Fatalf("expected a complete schema to be taken as version %d, got %d", LatestSchemaVersion, version)
+		}
+
+		// Running the printed SQL by hand records the schema's version too
+		pending, err := c.MigrationSQL(LatestSchemaVersion)
+		expectSuccess(t, err)
+		for _, statement := range pending {
+			expectSuccess(t, c.db.Exec(statement).Error)
+		}
+
+		var count int
+		expectSuccess(t, c.db.Table(schemaMigrationsTable).Count(&count).Error)
+		if count != LatestSchemaVersion {
+			t.Errorf("expected every version to be recorded by the printed SQL, got %d rows", count)
+		}
+
+		expectSuccess(t, c.MigrateTo(1))
+		expectSuccess(t, c.db.DropTable(schemaMigrationsTable).Error)
+		expectSuccess(t, c.Migrate())
+
+		count = 0
+		expectSuccess(t, c.db.Table(schemaMigrationsTable).Count(&count).Error)
+		if count != LatestSchemaVersion {
+			t.Errorf("expected every version to be recorded, got %d rows", count)
+		}
+
+		// A schema from the first release has only workers, queues and jobs
+		expectSuccess(t, c.MigrateTo(1))
+		expectSuccess(t, c.db.DropTable(schemaMigrationsTable).Error)
+
+		version, err = c.SchemaVersion()
+		expectSuccess(t, err)
+		if version != 1 {
+			t.Fatalf("expected the first release's schema to be taken as version 1, got %d", version)
+		}
+
+		expectSuccess(t, c.Migrate())
+		if !c.db.Dialect().HasColumn("jobs", "result_blob") || !c.db.HasTable("job_logs") {
+			t.Error("expected the later migrations to be applied after adopting version 1")
+		}
+	})
+}
+
+func TestMigrateRefusesUnrecognizedSchemas(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		// Part of version 2 is missing, but version 6's table exists
+		expectSuccess(t, c.MigrateTo(1))
+		expectSuccess(t, c.db.DropTable(schemaMigrationsTable).Error)
+		expectSuccess(t, c.db.Exec("CREATE TABLE job_stats (id integer)").Error)
+
+		if _, err := c.SchemaVersion(); err == nil || !strings.Contains(err.Error(), "job_stats") {
+			t.Errorf("expected a schema with gaps to be refused, got %v", err)
+		}
+
+		if err := c.Migrate(); err == nil {
+			t.Error("expected migrating a schema with gaps to fail")
+		}
+
+		expectSuccess(t, c.db.DropTable("job_stats").Error)
+		expectSuccess(t, c.MigrateTo(0))
+
+		// A jobs table which kigo didn't create
+		expectSuccess(t, c.db.Exec("CREATE TABLE jobs (id integer)").Error)
+		expectSuccess(t, c.db.DropTable(schemaMigrationsTable).Error)
+
+		if _, err := c.SchemaVersion(); err == nil {
+			t.Error("expected an unfamiliar jobs table to be refused")
+		}
+
+		expectSuccess(t, c.db.DropTable("jobs").Error)
+		expectSuccess(t, c.Migrate())
+	})
+}
+
+func TestMigrationSQL(t *testing.T) {
+	for dialect := range schemaDialects {
+		up, err := MigrationSQL(dialect, 0, LatestSchemaVersion)
+		expectSuccess(t, err)
+
+		script := strings.Join(up, ";\n")
+		if !strings.Contains(script, "CREATE TABLE jobs") || !strings.Contains(script, "INSERT INTO "+schemaMigrationsTable) {
+			t.Errorf("expected %s's script to create the schema and record it, got:\n%s", dialect, script)
+		}
+
+		down, err := MigrationSQL(dialect, LatestSchemaVersion, 0)
+		expectSuccess(t, err)
+
+		script = strings.Join(down, ";\n")
+		if !strings.Contains(script, "DROP TABLE jobs") || !strings.Contains(script, "DELETE FROM "+schemaMigrationsTable) {
+			t.Errorf("expected %s's script to drop the schema and unrecord it, got:\n%s", dialect, script)
+		}
+	}
+
+	mysql, err := MigrationSQL("mysql", 0, LatestSchemaVersion)
+	expectSuccess(t, err)
+	if !strings.Contains(strings.Join(mysql, "\n"), "enqueued_at DATETIME(6)") {
+		t.Errorf("expected MySQL times to be DATETIME(6), got:\n%s", strings.Join(mysql, "\n"))
+	}
+	if !strings.Contains(strings.Join(mysql, "\n"), "MODIFY error longtext") {
+		t.Errorf("expected MySQL's varchar(255) text columns to be widened, got:\n%s", strings.Join(mysql, "\n"))
+	}
+
+	postgres, err := MigrationSQL("postgres", 0, LatestSchemaVersion)
+	expectSuccess(t, err)
+	if !strings.Contains(strings.Join(postgres, "\n"), "queue_name varchar(255),\n  task_name text,\n  processed") {
+		t.Errorf("expected job_stats' task names to be unbounded like jobs', got:\n%s", strings.Join(postgres, "\n"))
+	}
+	if !strings.Contains(strings.Join(postgres, "\n"), "bucket_start timestamp with time zone,\n  task_name text,\n  bin") {
+		t.Errorf("expected job_durations' task names to be unbounded like jobs', got:\n%s", strings.Join(postgres, "\n"))
+	}
+	if !strings.Contains(strings.Join(mysql, "\n"), "queue_name, task_name(255))") || !strings.Contains(strings.Join(mysql, "\n"), "task_name(255), bin)") {
+		t.Errorf("expected MySQL to index a prefix of the rollups' task names, got:\n%s", strings.Join(mysql, "\n"))
+	}
+
+	if _, err := MigrationSQL("oracle", 0, 1); err == nil {
+		t.Error("expected an error for an unsupported dialect")
+	}
+
+	withConnection(t, func(c Connection) {
+		pending, err := c.MigrationSQL(LatestSchemaVersion)
+		expectSuccess(t, err)
+		if len(pending) != 1 {
+			t.Errorf("expected nothing pending besides the bookkeeping table, got %v", pending)
+		}
+	})
+}
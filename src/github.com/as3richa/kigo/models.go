@@ -1,20 +1,63 @@
 package kigo
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
-type jobState uint
+type JobState uint
 
 const (
-	jobEnqueued jobState = iota
-	jobRunning  jobState = iota
-	jobFailed   jobState = iota
-	jobFinished jobState = iota
+	JobEnqueued JobState = iota
+	JobRunning  JobState = iota
+	JobFailed   JobState = iota
+	JobFinished JobState = iota
 )
 
+var jobStateNames = map[JobState]string{
+	JobEnqueued: "enqueued",
+	JobRunning:  "running",
+	JobFailed:   "failed",
+	JobFinished: "finished",
+}
+
+func (s JobState) String() string {
+	if name, ok := jobStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("JobState(%d)", uint(s))
+}
+
+func ParseJobState(name string) (JobState, error) {
+	for state, stateName := range jobStateNames {
+		if stateName == name {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("no such job state %q", name)
+}
+
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *JobState) UnmarshalText(text []byte) error {
+	state, err := ParseJobState(string(text))
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+func (s JobState) terminal() bool {
+	return s == JobFailed || s == JobFinished
+}
+
 type workerModel struct {
 	ID uint
 
-	Name        string
+	Name        string       `gorm:"type:text"`
 	Queues      []queueModel `gorm:"many2many:worker_queues"`
 	Concurrency uint
 
@@ -24,7 +67,7 @@ type workerModel struct {
 }
 
 type queueModel struct {
-	Name string `gorm:"primary_key"`
+	Name string `gorm:"primary_key;size:255"`
 
 	Workers []workerModel `gorm:"many2many:worker_queues"`
 	Jobs    []jobModel    `gorm:"ForeignKey:QueueName"`
@@ -33,54 +76,140 @@ type queueModel struct {
 type jobModel struct {
 	ID uint
 
-	QueueName string
+	QueueName string `gorm:"size:255"`
 	Queue     *queueModel
 
 	WorkerID       *uint
 	Worker         *workerModel
 	WorkerThreadID *uint
 
+	TaskName string `gorm:"type:text"`
+
+	ParamBlob  []byte
+	ResultBlob []byte
+
+	State JobState
+
+	EnqueuedAt time.Time
+	StartAt    time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+
+	Error *string `gorm:"type:text"`
+
+	ProgressPercent *float64
+	ProgressMessage *string `gorm:"type:text"`
+	ProgressAt      *time.Time
+}
+
+type jobLogModel struct {
+	ID uint
+
+	JobID uint
+
+	Level   string
+	Message string  `gorm:"type:text"`
+	Fields  *string `gorm:"type:text"`
+
+	LoggedAt time.Time
+}
+
+// jobStatModel counts the jobs of one task and queue which were processed,
+// failed or retried within a bucket of Resolution seconds starting at
+// BucketStart.
+type jobStatModel struct {
+	ID uint
+
+	Resolution  uint
+	BucketStart time.Time
+
+	QueueName string `gorm:"size:255"`
+	TaskName  string
+
+	Processed uint
+	Failed    uint
+	Retried   uint
+}
+
+// jobDurationModel counts the jobs of one task which completed within a bucket
+// of Resolution seconds starting at BucketStart, and whose execution times fell
+// in one bin of the duration histogram.
+type jobDurationModel struct {
+	ID uint
+
+	Resolution  uint
+	BucketStart time.Time
+
 	TaskName string
+	Bin      uint
 
-	ParamBlob []byte
+	Completed uint
+	TotalUs   uint64
+	MaxUs     uint64
+}
 
-	State jobState
+// jobHistoryModel is an archived copy of a job which was pruned from the jobs
+// table.
+type jobHistoryModel struct {
+	ID uint
+
+	QueueName string
+	WorkerID  *uint
+	TaskName  string `gorm:"type:text"`
+
+	ParamBlob  []byte
+	ResultBlob []byte
+
+	State JobState
 
 	EnqueuedAt time.Time
 	StartAt    time.Time
 	StartedAt  *time.Time
 	FinishedAt *time.Time
 
-	Error *string
+	Error *string `gorm:"type:text"`
+
+	ArchivedAt time.Time
 }
 
-func (workerModel) TableName() string { return "workers" }
-func (queueModel) TableName() string  { return "queues" }
-func (jobModel) TableName() string    { return "jobs" }
+// leaseModel records which worker holds a named, expiring lease, which is used
+// to elect a single worker to perform a periodic task.
+type leaseModel struct {
+	Name string `gorm:"primary_key;size:255"`
 
-var jobIndexes = [][]string{
-	[]string{"jobs_queue_name_and_state_and_start_at_and_enqueued_at", "queue_name", "state", "start_at", "enqueued_at"},
-	[]string{"jobs_started_at", "started_at"},
+	WorkerID  uint
+	ExpiresAt time.Time
 }
 
-func (c Connection) Migrate() error {
-	if err := c.db.AutoMigrate(&workerModel{}, &queueModel{}, &jobModel{}).Error; err != nil {
-		return err
+func (j jobModel) progress() *JobProgress {
+	if j.ProgressAt == nil {
+		return nil
 	}
 
-	if err := c.db.Model(&jobModel{}).AddForeignKey("queue_name", "queues(name)", "RESTRICT", "RESTRICT").Error; err != nil {
-		return err
+	progress := JobProgress{ReportedAt: *j.ProgressAt}
+	if j.ProgressPercent != nil {
+		progress.Percent = *j.ProgressPercent
 	}
-
-	for _, index := range jobIndexes {
-		if err := c.db.Model(&jobModel{}).AddIndex(index[0], index[1:]...).Error; err != nil {
-			return err
-		}
+	if j.ProgressMessage != nil {
+		progress.Message = *j.ProgressMessage
 	}
 
-	return nil
+	return &progress
 }
 
+func (workerModel) TableName() string      { return "workers" }
+func (queueModel) TableName() string       { return "queues" }
+func (jobModel) TableName() string         { return "jobs" }
+func (jobLogModel) TableName() string      { return "job_logs" }
+func (jobStatModel) TableName() string     { return "job_stats" }
+func (jobDurationModel) TableName() string { return "job_durations" }
+func (jobHistoryModel) TableName() string  { return "job_history" }
+func (leaseModel) TableName() string       { return "leases" }
+
 func (c Connection) DropAll() error {
-	return c.db.DropTableIfExists(&jobModel{}, &workerModel{}, &queueModel{}).Error
+	if err := c.requireSQL(); err != nil {
+		return err
+	}
+
+	return c.db.DropTableIfExists(&leaseModel{}, &jobHistoryModel{}, &jobDurationModel{}, &jobStatModel{}, &jobLogModel{}, &jobModel{}, "worker_queues", &workerModel{}, &queueModel{}, schemaMigrationsTable).Error
 }
@@ -0,0 +1,40 @@
+package kigo
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// mysqlStore claims jobs in a transaction, since MySQL can't update and return
+// rows in one statement.
+type mysqlStore struct {
+	sqlStore
+}
+
+// ClaimJobs selects jobs with FOR UPDATE SKIP LOCKED, so that concurrent
+// claims pass over each other's jobs.
+func (s mysqlStore) ClaimJobs(workerID uint, queueNames []string, limit uint) ([]EncodedJob, error) {
+	jobRecords, err := s.claimInTransaction(workerID, queueNames, limit, "FOR UPDATE SKIP LOCKED")
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeJobs(jobRecords), nil
+}
+
+func (s mysqlStore) CancelJob(id uint) (EncodedJob, error) {
+	return cancelJob(s.db, s.cancelJobs, id)
+}
+
+func (s mysqlStore) cancelJobs(ids []uint) ([]jobModel, error) {
+	return updateJobsIn(s.db, s.lockRows, ids, JobEnqueued, cancelUpdates(time.Now()))
+}
+
+func (mysqlStore) insertIgnoringConflicts(db *gorm.DB, table string, columns string, values ...interface{}) (bool, error) {
+	return insertIgnoring(db, "INSERT IGNORE INTO", "", table, columns, values...)
+}
+
+func (mysqlStore) lockRows(query *gorm.DB) *gorm.DB {
+	return query.Set("gorm:query_option", "FOR UPDATE")
+}
@@ -0,0 +1,101 @@
+package kigo
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// postgresStore claims jobs in a single statement, and notifies WaitForJob
+// callers when jobs are done, so they needn't poll.
+type postgresStore struct {
+	sqlStore
+}
+
+// ClaimJobs claims jobs in a single statement, skipping over jobs which
+// concurrent claims have locked rather than waiting for them. RETURNING
+// yields rows in no particular order, so they're sorted afterwards.
+func (s postgresStore) ClaimJobs(workerID uint, queueNames []string, limit uint) ([]EncodedJob, error) {
+	now := time.Now()
+
+	var jobRecords []jobModel
+
+	err := s.db.Raw(`
+    UPDATE jobs SET state = ?, worker_id = ?, started_at = ?
+    WHERE id IN (
+      SELECT id FROM jobs
+      WHERE queue_name IN (?) AND state = ? AND start_at <= ?
+      ORDER BY enqueued_at ASC, id ASC LIMIT ? FOR UPDATE SKIP LOCKED)
+    RETURNING *`, JobRunning, workerID, now, queueNames, JobEnqueued, now, limit).Scan(&jobRecords).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobRecords, func(i, j int) bool {
+		if !jobRecords[i].EnqueuedAt.Equal(jobRecords[j].EnqueuedAt) {
+			return jobRecords[i].EnqueuedAt.Before(jobRecords[j].EnqueuedAt)
+		}
+		return jobRecords[i].ID < jobRecords[j].ID
+	})
+
+	return encodeJobs(jobRecords), nil
+}
+
+func (s postgresStore) FinishJob(id uint, result []byte) error {
+	if err := s.sqlStore.FinishJob(id, result); err != nil {
+		return err
+	}
+
+	_ = s.notifyJobDone(id)
+	return nil
+}
+
+func (s postgresStore) FailJob(id uint, message string) error {
+	if err := s.sqlStore.FailJob(id, message); err != nil {
+		return err
+	}
+
+	_ = s.notifyJobDone(id)
+	return nil
+}
+
+func (s postgresStore) CancelJob(id uint) (EncodedJob, error) {
+	return cancelJob(s.db, s.cancelJobs, id)
+}
+
+// cancelJobs notifies WaitForJob callers of the jobs which were cancelled,
+// and only those.
+func (s postgresStore) cancelJobs(ids []uint) ([]jobModel, error) {
+	var jobRecords []jobModel
+
+	err := s.db.Raw(`
+    UPDATE jobs SET state = ?, error = ?, finished_at = ?
+    WHERE id IN (?) AND state = ?
+    RETURNING *`, JobFailed, cancelledJobMessage, time.Now(), ids, JobEnqueued).Scan(&jobRecords).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, jobRecord := range jobRecords {
+		_ = s.notifyJobDone(jobRecord.ID)
+	}
+
+	return jobRecords, nil
+}
+
+// notifyJobDone wakes up WaitForJob callers waiting on the job.
+func (s postgresStore) notifyJobDone(id uint) error {
+	return s.db.Exec("SELECT pg_notify(?, ?)", jobDoneChannel, strconv.FormatUint(uint64(id), 10)).Error
+}
+
+func (postgresStore) insertIgnoringConflicts(db *gorm.DB, table string, columns string, values ...interface{}) (bool, error) {
+	return insertIgnoring(db, "INSERT INTO", " ON CONFLICT DO NOTHING", table, columns, values...)
+}
+
+func (postgresStore) lockRows(query *gorm.DB) *gorm.DB {
+	return query.Set("gorm:query_option", "FOR UPDATE")
+}
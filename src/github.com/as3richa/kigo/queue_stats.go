@@ -0,0 +1,178 @@
+package kigo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type QueueStats struct {
+	Name string
+
+	// Counts holds the number of jobs in each state.
+	Counts map[JobState]uint
+
+	// Scheduled is the number of enqueued jobs whose StartAt is in the future.
+	Scheduled uint
+
+	// Latency is the age of the oldest runnable job, measured from the time it
+	// became runnable, or zero if there are no runnable jobs.
+	Latency time.Duration
+
+	// LiveWorkers is the number of healthy workers consuming the queue.
+	LiveWorkers uint
+}
+
+func (c Connection) QueueStats() ([]QueueStats, error) {
+	if err := c.requireSQL(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var queueRecords []queueModel
+	if err := c.db.Order("name ASC").Find(&queueRecords).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]QueueStats, len(queueRecords))
+	byName := map[string]*QueueStats{}
+	for i, queueRecord := range queueRecords {
+		stats[i] = QueueStats{Name: queueRecord.Name, Counts: map[JobState]uint{}}
+		for state := range jobStateNames {
+			stats[i].Counts[state] = 0
+		}
+		byName[queueRecord.Name] = &stats[i]
+	}
+
+	rows, err := c.db.Raw(`
+    SELECT queue_name, state, COUNT(*) FROM jobs
+    GROUP BY queue_name, state`).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var queueName string
+		var state JobState
+		var count uint
+
+		if err := rows.Scan(&queueName, &state, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if queueStats, ok := byName[queueName]; ok {
+			queueStats.Counts[state] = count
+		}
+	}
+	rows.Close()
+
+	rows, err = c.db.Raw(`
+    SELECT queue_name, COUNT(*) FROM jobs
+    WHERE state = ? AND start_at > ?
+    GROUP BY queue_name`, JobEnqueued, now).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var queueName string
+		var scheduled uint
+
+		if err := rows.Scan(&queueName, &scheduled); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if queueStats, ok := byName[queueName]; ok {
+			queueStats.Scheduled = scheduled
+		}
+	}
+	rows.Close()
+
+	rows, err = c.db.Raw(`
+    SELECT queue_name, MIN(start_at) FROM jobs
+    WHERE state = ? AND start_at <= ?
+    GROUP BY queue_name`, JobEnqueued, now).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var queueName string
+		var oldestRunnable aggregateTime
+
+		if err := rows.Scan(&queueName, &oldestRunnable); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if queueStats, ok := byName[queueName]; ok {
+			queueStats.Latency = now.Sub(oldestRunnable.Time)
+		}
+	}
+	rows.Close()
+
+	rows, err = c.db.Raw(`
+    SELECT worker_queues.queue_model_name, COUNT(*) FROM worker_queues
+    JOIN workers ON workers.id = worker_queues.worker_model_id
+    WHERE workers.heartbeat_at >= ? AND workers.stopped_at IS NULL
+    GROUP BY worker_queues.queue_model_name`, now.Add(-workerStaleThreshold)).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var queueName string
+		var liveWorkers uint
+
+		if err := rows.Scan(&queueName, &liveWorkers); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if queueStats, ok := byName[queueName]; ok {
+			queueStats.LiveWorkers = liveWorkers
+		}
+	}
+	rows.Close()
+
+	return stats, nil
+}
+
+// aggregateTime scans the result of an aggregate over a timestamp column.
+// SQLite doesn't know the result is a timestamp, so it comes back as text.
+type aggregateTime struct {
+	time.Time
+}
+
+func (t *aggregateTime) Scan(value interface{}) error {
+	var text string
+
+	switch value := value.(type) {
+	case time.Time:
+		t.Time = value
+		return nil
+	case []byte:
+		text = string(value)
+	case string:
+		text = value
+	default:
+		return fmt.Errorf("can't scan %T as a time", value)
+	}
+
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.Parse(format, text); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't parse %q as a time", text)
+}
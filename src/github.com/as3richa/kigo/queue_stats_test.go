@@ -0,0 +1,44 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueStats(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		_, err := c.createWorker("test/1", []string{"alpha"}, 1)
+		expectSuccess(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err := c.pushJobTo("alpha", "stat", []interface{}{}, time.Now().Add(-time.Minute))
+			expectSuccess(t, err)
+		}
+
+		_, err = c.pushJobTo("alpha", "stat", []interface{}{}, time.Now().Add(time.Hour))
+		expectSuccess(t, err)
+
+		failed, err := c.pushJobTo("beta", "stat", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+		markRunning(t, c, failed)
+		expectSuccess(t, c.failJob(failed, errors.New("augh")))
+
+		stats, err := c.QueueStats()
+		expectSuccess(t, err)
+
+		if len(stats) != 2 || stats[0].Name != "alpha" || stats[1].Name != "beta" {
+			t.Fatalf("unexpected queues %v", stats)
+		}
+
+		alpha := stats[0]
+		if alpha.Counts[JobEnqueued] != 4 || alpha.Scheduled != 1 || alpha.LiveWorkers != 1 || alpha.Latency < time.Minute {
+			t.Errorf("unexpected stats for alpha: %+v", alpha)
+		}
+
+		beta := stats[1]
+		if beta.Counts[JobFailed] != 1 || beta.Counts[JobEnqueued] != 0 || beta.Latency != 0 || beta.LiveWorkers != 0 {
+			t.Errorf("unexpected stats for beta: %+v", beta)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package kigo
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+const pruneInterval = time.Minute
+const pruneLeaseName = "pruner"
+const defaultPruneBatchSize = 500
+
+const archivedJobColumns = "id, queue_name, worker_id, task_name, param_blob, result_blob, state, enqueued_at, start_at, started_at, finished_at, error"
+
+// Retention is how long finished and failed jobs are kept after they complete.
+// A zero duration keeps jobs in that state forever.
+type Retention struct {
+	Finished time.Duration
+	Failed   time.Duration
+}
+
+// RetentionPolicy applies Default to every queue without an entry in Queues.
+// If Archive is set, pruned jobs are copied to the job_history table before
+// they're deleted from jobs. Jobs are pruned at most BatchSize (default 500)
+// at a time, so that pruning doesn't hold locks on much of the jobs table.
+type RetentionPolicy struct {
+	Default Retention
+	Queues  map[string]Retention
+
+	Archive   bool
+	BatchSize uint
+}
+
+type pruneRule struct {
+	state JobState
+	keep  time.Duration
+	scope func(*gorm.DB) *gorm.DB
+}
+
+func (p RetentionPolicy) rules() []pruneRule {
+	var rules []pruneRule
+
+	add := func(retention Retention, scope func(*gorm.DB) *gorm.DB) {
+		if retention.Finished > 0 {
+			rules = append(rules, pruneRule{JobFinished, retention.Finished, scope})
+		}
+		if retention.Failed > 0 {
+			rules = append(rules, pruneRule{JobFailed, retention.Failed, scope})
+		}
+	}
+
+	overridden := make([]string, 0, len(p.Queues))
+	for queueName, retention := range p.Queues {
+		queueName := queueName
+		overridden = append(overridden, queueName)
+
+		add(retention, func(query *gorm.DB) *gorm.DB {
+			return query.Where("queue_name = ?", queueName)
+		})
+	}
+
+	add(p.Default, func(query *gorm.DB) *gorm.DB {
+		if len(overridden) == 0 {
+			return query
+		}
+		return query.Where("queue_name NOT IN (?)", overridden)
+	})
+
+	return rules
+}
+
+// PruneJobs deletes, and optionally archives, the finished and failed jobs
+// which the policy no longer retains. It returns the number of jobs pruned.
+func (c Connection) PruneJobs(policy RetentionPolicy) (uint, error) {
+	return c.pruneJobs(policy, time.Now(), nil)
+}
+
+func (c Connection) pruneJobs(policy RetentionPolicy, now time.Time, terminator <-chan struct{}) (uint, error) {
+	if err := c.requireSQL(); err != nil {
+		return 0, err
+	}
+
+	batchSize := policy.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultPruneBatchSize
+	}
+
+	var total uint
+
+	for _, rule := range policy.rules() {
+		cutoff := now.Add(-rule.keep)
+
+		for {
+			select {
+			case <-terminator:
+				return total, nil
+			default:
+			}
+
+			pruned, err := c.pruneBatch(rule, cutoff, batchSize, policy.Archive, now)
+			total += pruned
+
+			if err != nil {
+				return total, err
+			}
+
+			if pruned < batchSize {
+				break
+			}
+		}
+	}
+
+	return total, nil
+}
+
+func (c Connection) pruneBatch(rule pruneRule, cutoff time.Time, batchSize uint, archive bool, now time.Time) (uint, error) {
+	var ids []uint
+	err := rule.scope(c.db.Model(&jobModel{})).
+		Where("state = ? AND finished_at < ?", rule.state, cutoff).
+		Order("id").
+		Limit(batchSize).
+		Pluck("id", &ids).Error
+
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	tx := c.db.Begin()
+
+	if archive {
+		err := tx.Exec(
+			"INSERT INTO job_history ("+archivedJobColumns+", archived_at) SELECT "+archivedJobColumns+", ? FROM jobs WHERE id IN (?) AND state = ?",
+			now, ids, rule.state,
+		).Error
+
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	query := tx.Where("id IN (?) AND state = ?", ids, rule.state).Delete(&jobModel{})
+	if query.Error != nil {
+		tx.Rollback()
+		return 0, query.Error
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	return uint(len(ids)), nil
+}
+
+// acquireLease claims the named lease for the worker until ttl from now, or
+// extends it if the worker already holds it. It fails if another worker holds
+// a lease which hasn't expired.
+func (c Connection) acquireLease(name string, workerID uint, ttl time.Duration) (bool, error) {
+	if err := c.requireSQL(); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+
+	query := c.db.Model(&leaseModel{}).
+		Where("name = ? AND (worker_id = ? OR expires_at < ?)", name, workerID, now).
+		Updates(map[string]interface{}{"worker_id": workerID, "expires_at": now.Add(ttl)})
+
+	if query.Error != nil {
+		return false, query.Error
+	}
+
+	if query.RowsAffected != 0 {
+		return true, nil
+	}
+
+	return c.backend().insertIgnoringConflicts(c.db, "leases", "name, worker_id, expires_at", name, workerID, now.Add(ttl))
+}
+
+// pruner periodically prunes jobs according to the policy, on whichever single
+// worker holds the pruner lease.
+func (w *worker) pruner(c Connection, policy RetentionPolicy) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if leader, err := c.acquireLease(pruneLeaseName, w.id, 3*pruneInterval); err != nil {
+			w.log.WithFields(logrus.Fields{"error": err}).Error("failed to acquire pruner lease")
+		} else if leader {
+			pruned, err := c.pruneJobs(policy, time.Now(), w.subroutineTerminator)
+			if err != nil {
+				w.log.WithFields(logrus.Fields{"error": err, "pruned": pruned}).Error("failed to prune jobs")
+			} else if pruned != 0 {
+				w.log.WithFields(logrus.Fields{"pruned": pruned}).Info("pruned jobs")
+			}
+		}
+
+		select {
+		case <-w.subroutineTerminator:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyRules(t *testing.T) {
+	policy := RetentionPolicy{
+		Default: Retention{Finished: 3 * 24 * time.Hour, Failed: 30 * 24 * time.Hour},
+		Queues: map[string]Retention{
+			"audit": {Failed: 90 * 24 * time.Hour},
+		},
+	}
+
+	rules := policy.rules()
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, got %d", len(rules))
+	}
+
+	if rules[0].state != JobFailed || rules[0].keep != 90*24*time.Hour {
+		t.Errorf("expected the audit queue's failed rule first, got %+v", rules[0])
+	}
+
+	if rules[1].state != JobFinished || rules[2].state != JobFailed {
+		t.Errorf("expected default finished and failed rules, got %+v", rules[1:])
+	}
+}
+
+func TestPruneJobsArchivesInBatches(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		workerID, err := c.createWorker("pruner", []string{"alpha", "audit"}, 10)
+		expectSuccess(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err := c.pushJobTo("alpha", "prune", []interface{}{i}, time.Now())
+			expectSuccess(t, err)
+		}
+
+		auditID, err := c.pushJobTo("audit", "prune", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		keptID, err := c.pushJobTo("alpha", "prune", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		jobs, _, err := c.popJobsFrom(workerID, []string{"alpha", "audit"}, 10)
+		expectSuccess(t, err)
+
+		for _, job := range jobs {
+			if job.ID == keptID {
+				continue
+			}
+			expectSuccess(t, c.failJob(job.ID, errors.New("augh")))
+		}
+
+		policy := RetentionPolicy{
+			Default:   Retention{Failed: time.Hour},
+			Queues:    map[string]Retention{"audit": {Failed: 90 * 24 * time.Hour}},
+			Archive:   true,
+			BatchSize: 2,
+		}
+
+		pruned, err := c.pruneJobs(policy, time.Now().Add(2*time.Hour), nil)
+		expectSuccess(t, err)
+		if pruned != 5 {
+			t.Errorf("expected 5 jobs pruned, got %d", pruned)
+		}
+
+		var archived int
+		expectSuccess(t, c.db.Model(&jobHistoryModel{}).Count(&archived).Error)
+		if archived != 5 {
+			t.Errorf("expected 5 jobs archived, got %d", archived)
+		}
+
+		for _, id := range []uint{auditID, keptID} {
+			if _, err := c.FindJob(id); err != nil {
+				t.Errorf("expected job %d to be retained, got %v", id, err)
+			}
+		}
+	})
+}
+
+func TestPrunerLease(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		acquired, err := c.acquireLease("test", 1, time.Minute)
+		expectSuccess(t, err)
+		if !acquired {
+			t.Error("expected the first worker to acquire the lease")
+		}
+
+		acquired, err = c.acquireLease("test", 2, time.Minute)
+		expectSuccess(t, err)
+		if acquired {
+			t.Error("expected the lease to be held by the first worker")
+		}
+
+		acquired, err = c.acquireLease("test", 1, -time.Minute)
+		expectSuccess(t, err)
+		if !acquired {
+			t.Error("expected the first worker to renew the lease")
+		}
+
+		acquired, err = c.acquireLease("test", 2, time.Minute)
+		expectSuccess(t, err)
+		if !acquired {
+			t.Error("expected the second worker to take over the expired lease")
+		}
+	})
+}
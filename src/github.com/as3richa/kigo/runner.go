@@ -0,0 +1,132 @@
+package kigo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jobRunner runs claimed jobs and records their progress, logs and outcomes.
+// Workers and ExecuteJob both run jobs through it, so that jobs are run and
+// reported the same way with or without a worker.
+type jobRunner struct {
+	c    Connection
+	log  logrus.FieldLogger
+	emit func(Event)
+}
+
+type jobOutcome struct {
+	result   []byte
+	err      error
+	duration time.Duration
+}
+
+// reject reports a claimed job which couldn't be decoded. The store has
+// already been asked to fail it, with failErr as the result.
+func (r jobRunner) reject(job *Job, err error, failErr error) {
+	r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": err}).Error("couldn't decode job")
+	if failErr != nil {
+		r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": failErr}).Error("couldn't fail job")
+	}
+	r.failed(job, err, 0)
+}
+
+// start runs a claimed job on a new goroutine, and calls done with its outcome.
+// Before the job is reported as started, started is called, if set, with its
+// terminator, which is nil if the task can't be cancelled. A job which can't
+// be started is failed, and start returns the reason.
+func (r jobRunner) start(job *Job, started func(terminator chan struct{}), done func(jobOutcome)) error {
+	r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName}).Info("popped job")
+	r.emit(Event{Kind: JobClaimedEvent, Job: job})
+
+	sinks := taskSinks{
+		log: r.log,
+		saveProgress: func(progress JobProgress) {
+			if err := r.c.updateJobProgress(job.ID, progress); err != nil {
+				r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": err}).Error("couldn't save job progress")
+			}
+		},
+		saveLogs: func(lines []JobLogLine) {
+			if err := r.c.appendJobLogs(job.ID, lines); err != nil {
+				r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": err}).Error("couldn't save job logs")
+			}
+		},
+	}
+
+	startedAt := time.Now()
+
+	terminator, err := performTaskAsync(newTaskContext(job, sinks), func(result []byte, err error) {
+		done(jobOutcome{result: result, err: err, duration: time.Since(startedAt)})
+	})
+
+	if err != nil {
+		r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": err}).Info("couldn't start job")
+		err = fmt.Errorf("couldn't start job %d: %v", job.ID, err)
+		if r.fail(job, err) != ErrJobNotRunning {
+			r.failed(job, err, 0)
+		}
+		return err
+	}
+
+	if started != nil {
+		started(terminator)
+	}
+
+	r.emit(Event{Kind: JobStartedEvent, Job: job})
+	return nil
+}
+
+// complete records a job's outcome and reports it. A job which was asked to
+// stop and failed is recorded as cancelled. It returns the error recording
+// the outcome, if any. A job which stopped running before its outcome could
+// be recorded, e.g. because it was cancelled, isn't reported again.
+func (r jobRunner) complete(job *Job, outcome jobOutcome, cancelled bool) error {
+	fields := logrus.Fields{"id": job.ID, "taskName": job.TaskName}
+
+	if outcome.err == nil {
+		r.log.WithFields(fields).Info("job finished peacefully")
+
+		err := r.c.finishJob(job.ID, outcome.result)
+		if err == ErrJobNotRunning {
+			r.log.WithFields(fields).Info("job was cancelled before it finished")
+			return err
+		} else if err != nil {
+			r.log.WithFields(fields).WithFields(logrus.Fields{"error": err}).Error("couldn't finish job")
+		}
+
+		r.emit(Event{Kind: JobFinishedEvent, Job: job, Duration: outcome.duration})
+		return err
+	}
+
+	var err error
+	if cancelled {
+		r.log.WithFields(fields).WithFields(logrus.Fields{"error": outcome.err}).Info("job was cancelled")
+		err = r.fail(job, fmt.Errorf("job %d was cancelled: %v", job.ID, outcome.err))
+	} else {
+		r.log.WithFields(fields).WithFields(logrus.Fields{"error": outcome.err}).Error("job failed")
+		err = r.fail(job, fmt.Errorf("job %d failed: %v", job.ID, outcome.err))
+	}
+
+	if err != ErrJobNotRunning {
+		r.failed(job, outcome.err, outcome.duration)
+	}
+	return err
+}
+
+// failed reports a job which failed, and which is dead since it won't be
+// retried.
+func (r jobRunner) failed(job *Job, err error, duration time.Duration) {
+	r.emit(Event{Kind: JobFailedEvent, Job: job, Error: err, Duration: duration})
+	r.emit(Event{Kind: JobDeadEvent, Job: job, Error: err})
+}
+
+func (r jobRunner) fail(job *Job, err error) error {
+	failErr := r.c.failJob(job.ID, err)
+	if failErr == ErrJobNotRunning {
+		r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName}).Info("job was cancelled before it failed")
+	} else if failErr != nil {
+		r.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": failErr}).Error("couldn't fail job")
+	}
+	return failErr
+}
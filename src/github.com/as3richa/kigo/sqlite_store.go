@@ -0,0 +1,41 @@
+package kigo
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// sqliteStore relies on SQLite allowing one writer at a time: transactions
+// take the database's write lock up front (_txlock=immediate), so they're
+// serialized rather than locking rows.
+type sqliteStore struct {
+	sqlStore
+}
+
+func (s sqliteStore) ClaimJobs(workerID uint, queueNames []string, limit uint) ([]EncodedJob, error) {
+	jobRecords, err := s.claimInTransaction(workerID, queueNames, limit, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeJobs(jobRecords), nil
+}
+
+func (s sqliteStore) CancelJob(id uint) (EncodedJob, error) {
+	return cancelJob(s.db, s.cancelJobs, id)
+}
+
+func (s sqliteStore) cancelJobs(ids []uint) ([]jobModel, error) {
+	return updateJobsIn(s.db, s.lockRows, ids, JobEnqueued, cancelUpdates(time.Now()))
+}
+
+// insertIgnoringConflicts uses SQLite's own syntax, since the vendored SQLite
+// predates ON CONFLICT.
+func (sqliteStore) insertIgnoringConflicts(db *gorm.DB, table string, columns string, values ...interface{}) (bool, error) {
+	return insertIgnoring(db, "INSERT OR IGNORE INTO", "", table, columns, values...)
+}
+
+func (sqliteStore) lockRows(query *gorm.DB) *gorm.DB {
+	return query
+}
@@ -0,0 +1,450 @@
+package kigo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrUnsupported is returned by operations which need a SQL database, such as
+// listing jobs or workers, on a Connection backed by another Store.
+var ErrUnsupported = errors.New("not supported by this connection's store")
+
+// Store is the storage backend underlying a Connection. It covers the
+// operations workers and clients need to run jobs: registering workers and
+// their heartbeats; enqueueing, claiming, finishing and failing jobs; and
+// recording and fetching jobs' outcomes, progress and logs. Lookups of jobs
+// which don't exist fail with gorm.ErrRecordNotFound.
+type Store interface {
+	// Ping checks that the store is reachable, for health checks.
+	Ping() error
+
+	CreateWorker(name string, queueNames []string, concurrency uint) (uint, error)
+	BeatWorker(id uint) error
+
+	// TerminateWorker removes a worker, failing any jobs it was running.
+	TerminateWorker(id uint) error
+
+	PushJob(queueName string, taskName string, paramBlob []byte, startAt time.Time) (uint, error)
+
+	// ClaimJobs atomically marks up to limit runnable jobs from the given
+	// queues as running on the worker, oldest first, and returns them. No
+	// job may be claimed by more than one worker.
+	ClaimJobs(workerID uint, queueNames []string, limit uint) ([]EncodedJob, error)
+
+	// FinishJob and FailJob record a running job's outcome. They fail with
+	// ErrJobNotRunning, and record nothing, if the job has stopped running,
+	// e.g. because it was cancelled or its worker was terminated.
+	FinishJob(id uint, result []byte) error
+	FailJob(id uint, message string) error
+
+	// CancelJob fails an enqueued job, so that it never runs, and returns it.
+	// It fails with ErrJobNotEnqueued if the job isn't enqueued.
+	CancelJob(id uint) (EncodedJob, error)
+
+	FetchJobOutcome(id uint) (EncodedOutcome, error)
+
+	// SaveJobProgress records a running job's progress; it does nothing if
+	// the job isn't running.
+	SaveJobProgress(id uint, progress JobProgress) error
+	FetchJobProgress(id uint) (*JobProgress, error)
+
+	// AppendJobLogs adds lines to the end of a job's logs, then discards all
+	// but the last keep lines.
+	AppendJobLogs(id uint, lines []JobLogLine, keep uint) error
+	FetchJobLogs(id uint) ([]JobLogLine, error)
+}
+
+// EncodedJob is a claimed job whose parameters are still serialized.
+type EncodedJob struct {
+	ID        uint
+	QueueName string
+	TaskName  string
+	ParamBlob []byte
+}
+
+// Decode deserializes the job's parameters.
+func (e EncodedJob) Decode() (*Job, error) {
+	return decodeJob(jobModel{
+		ID:        e.ID,
+		QueueName: e.QueueName,
+		TaskName:  e.TaskName,
+		ParamBlob: e.ParamBlob,
+	})
+}
+
+// EncodedOutcome is the state of a job, with its error message if it failed
+// and its serialized result if it finished.
+type EncodedOutcome struct {
+	State      JobState
+	Error      *string
+	ResultBlob []byte
+}
+
+// sqlBackend is a Store backed by a SQL database. There's one implementation
+// per database, which holds whatever differs between them; the rest is shared
+// through sqlStore.
+type sqlBackend interface {
+	Store
+
+	// insertIgnoringConflicts inserts a row unless it would violate a unique
+	// constraint, and reports whether it was inserted.
+	insertIgnoringConflicts(db *gorm.DB, table string, columns string, values ...interface{}) (bool, error)
+
+	// lockRows makes a query in a transaction lock the rows it selects until
+	// the transaction ends, so that concurrent transactions don't both act on
+	// them.
+	lockRows(query *gorm.DB) *gorm.DB
+
+	// cancelJobs fails those of the given jobs which are enqueued, as
+	// CancelJob does, and returns the jobs it cancelled.
+	cancelJobs(ids []uint) ([]jobModel, error)
+}
+
+// sqlStore implements the parts of Store which are the same on every SQL
+// database, with gorm.
+type sqlStore struct {
+	db *gorm.DB
+}
+
+func (s sqlStore) CreateWorker(name string, queueNames []string, concurrency uint) (uint, error) {
+	queueRecords := make([]queueModel, len(queueNames))
+	for i := 0; i < len(queueNames); i++ {
+		queueRecords[i].Name = queueNames[i]
+	}
+
+	now := time.Now()
+
+	workerRecord := workerModel{
+		Name:        name,
+		Queues:      queueRecords,
+		Concurrency: concurrency,
+		StartedAt:   now,
+		HeartbeatAt: now,
+	}
+
+	if err := s.db.Create(&workerRecord).Error; err != nil {
+		return 0, err
+	}
+
+	return workerRecord.ID, nil
+}
+
+func (s sqlStore) Ping() error {
+	return s.db.DB().Ping()
+}
+
+func (s sqlStore) BeatWorker(id uint) error {
+	return s.db.Model(&workerModel{}).Where("id = ?", id).Update("heartbeat_at", time.Now()).Error
+}
+
+func (s sqlStore) TerminateWorker(id uint) error {
+	message := "worker was terminated"
+
+	tx := s.db.Begin()
+
+	err := tx.Model(&jobModel{}).Where("worker_id = ?", id).Update(map[string]interface{}{
+		"state":       JobFailed,
+		"worker_id":   nil,
+		"error":       message,
+		"finished_at": time.Now(),
+	}).Error
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Model(&workerModel{}).Delete(workerModel{ID: id}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (s sqlStore) PushJob(queueName string, taskName string, paramBlob []byte, startAt time.Time) (uint, error) {
+	jobRecord := jobModel{
+		QueueName:  queueName,
+		Queue:      &queueModel{Name: queueName},
+		TaskName:   taskName,
+		ParamBlob:  paramBlob,
+		State:      JobEnqueued,
+		EnqueuedAt: time.Now(),
+		StartAt:    startAt,
+	}
+
+	if err := s.db.Create(&jobRecord).Error; err != nil {
+		return 0, err
+	}
+
+	return jobRecord.ID, nil
+}
+
+// claimInTransaction claims jobs for databases which can't update and return
+// rows in one statement. The jobs are selected with the given locking clause
+// (e.g. FOR UPDATE SKIP LOCKED on MySQL) so that concurrent claims pass over
+// each other's jobs. Without one, it relies on the transaction holding the
+// database's write lock from the start (as SQLite does with _txlock=immediate),
+// so that concurrent claims are serialized.
+func (s sqlStore) claimInTransaction(workerID uint, queueNames []string, limit uint, lock string) ([]jobModel, error) {
+	now := time.Now()
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	query := tx.Select("id").
+		Where("queue_name IN (?) AND state = ? AND start_at <= ?", queueNames, JobEnqueued, now).
+		Order("enqueued_at ASC, id ASC").
+		Limit(limit)
+
+	if lock != "" {
+		query = query.Set("gorm:query_option", lock)
+	}
+
+	var claimable []jobModel
+	if err := query.Find(&claimable).Error; err != nil || len(claimable) == 0 {
+		tx.Rollback()
+		return nil, err
+	}
+
+	ids := make([]uint, len(claimable))
+	for i, jobRecord := range claimable {
+		ids[i] = jobRecord.ID
+	}
+
+	err := tx.Model(&jobModel{}).Where("id IN (?)", ids).Update(map[string]interface{}{
+		"state":      JobRunning,
+		"worker_id":  workerID,
+		"started_at": now,
+	}).Error
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var jobRecords []jobModel
+	if err := tx.Where("id IN (?)", ids).Order("enqueued_at ASC, id ASC").Find(&jobRecords).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return jobRecords, nil
+}
+
+func (s sqlStore) FinishJob(id uint, result []byte) error {
+	query := s.db.Model(&jobModel{}).Where("id = ? AND state = ?", id, JobRunning).Update(map[string]interface{}{
+		"state":       JobFinished,
+		"worker_id":   nil,
+		"finished_at": time.Now(),
+		"error":       nil,
+		"result_blob": result,
+	})
+
+	return checkJobAction(query, ErrJobNotRunning)
+}
+
+func (s sqlStore) FailJob(id uint, message string) error {
+	query := s.db.Model(&jobModel{}).Where("id = ? AND state = ?", id, JobRunning).Update(map[string]interface{}{
+		"state":       JobFailed,
+		"worker_id":   nil,
+		"error":       message,
+		"finished_at": time.Now(),
+	})
+
+	return checkJobAction(query, ErrJobNotRunning)
+}
+
+// cancelJob implements CancelJob with a backend's cancelJobs.
+func cancelJob(db *gorm.DB, cancelJobs func([]uint) ([]jobModel, error), id uint) (EncodedJob, error) {
+	if err := db.Where("id = ?", id).First(&jobModel{}).Error; err != nil {
+		return EncodedJob{}, err
+	}
+
+	cancelled, err := cancelJobs([]uint{id})
+	if err != nil {
+		return EncodedJob{}, err
+	}
+
+	if len(cancelled) == 0 {
+		return EncodedJob{}, ErrJobNotEnqueued
+	}
+
+	return encodeJobs(cancelled)[0], nil
+}
+
+// updateJobsIn applies updates to those of the given jobs which are in the
+// given state, and returns them as they were. The jobs are locked until
+// they're updated, so that a job which changes state concurrently is neither
+// updated nor returned.
+func updateJobsIn(db *gorm.DB, lockRows func(*gorm.DB) *gorm.DB, ids []uint, state JobState, updates map[string]interface{}) ([]jobModel, error) {
+	tx := db.Begin()
+
+	var jobRecords []jobModel
+	err := lockRows(tx).Where("id IN (?) AND state = ?", ids, state).Find(&jobRecords).Error
+	if err != nil || len(jobRecords) == 0 {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Model(&jobModel{}).Where("id IN (?)", jobIDs(jobRecords)).Update(updates).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return jobRecords, tx.Commit().Error
+}
+
+func jobIDs(jobRecords []jobModel) []uint {
+	ids := make([]uint, len(jobRecords))
+	for i, jobRecord := range jobRecords {
+		ids[i] = jobRecord.ID
+	}
+	return ids
+}
+
+func (s sqlStore) FetchJobOutcome(id uint) (EncodedOutcome, error) {
+	var jobRecord jobModel
+	if err := s.db.Select("state, error, result_blob").Where("id = ?", id).First(&jobRecord).Error; err != nil {
+		return EncodedOutcome{}, err
+	}
+	return EncodedOutcome{State: jobRecord.State, Error: jobRecord.Error, ResultBlob: jobRecord.ResultBlob}, nil
+}
+
+func (s sqlStore) SaveJobProgress(id uint, progress JobProgress) error {
+	return s.db.Model(&jobModel{}).Where("id = ? AND state = ?", id, JobRunning).Update(map[string]interface{}{
+		"progress_percent": progress.Percent,
+		"progress_message": progress.Message,
+		"progress_at":      progress.ReportedAt,
+	}).Error
+}
+
+func (s sqlStore) FetchJobProgress(id uint) (*JobProgress, error) {
+	var jobRecord jobModel
+	err := s.db.Select("progress_percent, progress_message, progress_at").Where("id = ?", id).First(&jobRecord).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobRecord.progress(), nil
+}
+
+func (s sqlStore) AppendJobLogs(id uint, lines []JobLogLine, keep uint) error {
+	tx := s.db.Begin()
+
+	for _, line := range lines {
+		logRecord := jobLogModel{
+			JobID:    id,
+			Level:    line.Level,
+			Message:  line.Message,
+			LoggedAt: line.LoggedAt,
+		}
+
+		if len(line.Fields) > 0 {
+			fields, err := json.Marshal(line.Fields)
+			if err != nil {
+				fields, _ = json.Marshal(map[string]string{"unserializable": fmt.Sprint(line.Fields)})
+			}
+			serializedFields := string(fields)
+			logRecord.Fields = &serializedFields
+		}
+
+		if err := tx.Create(&logRecord).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Find the oldest line to keep, if there are more than keep lines, and
+	// delete everything before it
+	var oldest []jobLogModel
+	err := tx.Select("id").Where("job_id = ?", id).Order("id DESC").Offset(keep - 1).Limit(1).Find(&oldest).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(oldest) != 0 {
+		if err := tx.Where("job_id = ? AND id < ?", id, oldest[0].ID).Delete(&jobLogModel{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (s sqlStore) FetchJobLogs(id uint) ([]JobLogLine, error) {
+	var jobRecord jobModel
+	if err := s.db.Select("id").Where("id = ?", id).First(&jobRecord).Error; err != nil {
+		return nil, err
+	}
+
+	var logRecords []jobLogModel
+	if err := s.db.Where("job_id = ?", id).Order("id ASC").Find(&logRecords).Error; err != nil {
+		return nil, err
+	}
+
+	lines := make([]JobLogLine, len(logRecords))
+	for i, logRecord := range logRecords {
+		lines[i] = JobLogLine{
+			Level:    logRecord.Level,
+			Message:  logRecord.Message,
+			LoggedAt: logRecord.LoggedAt,
+		}
+
+		if logRecord.Fields != nil {
+			if err := json.Unmarshal([]byte(*logRecord.Fields), &lines[i].Fields); err != nil {
+				return nil, fmt.Errorf("deserialization failure: %v", err)
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+// insertIgnoring runs an insert of one row, using the database's syntax for
+// ignoring conflicts.
+func insertIgnoring(db *gorm.DB, insert string, suffix string, table string, columns string, values ...interface{}) (bool, error) {
+	placeholders := "?"
+	for i := 1; i < len(values); i++ {
+		placeholders += ", ?"
+	}
+
+	query := db.Exec(insert+" "+table+" ("+columns+") VALUES ("+placeholders+")"+suffix, values...)
+	return query.RowsAffected != 0, query.Error
+}
+
+func encodeJobs(jobRecords []jobModel) []EncodedJob {
+	jobs := make([]EncodedJob, len(jobRecords))
+	for i, jobRecord := range jobRecords {
+		jobs[i] = EncodedJob{
+			ID:        jobRecord.ID,
+			QueueName: jobRecord.QueueName,
+			TaskName:  jobRecord.TaskName,
+			ParamBlob: jobRecord.ParamBlob,
+		}
+	}
+	return jobs
+}
@@ -1,19 +1,28 @@
 package kigo
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 const defaultQueueName = "default"
 
+var ErrJobNotFinished = errors.New("job has not finished")
+var ErrNoJobResult = errors.New("job did not return a result")
+
 var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
 var typeOfTerminator = reflect.TypeOf((*chan struct{})(nil)).Elem()
+var typeOfTaskContext = reflect.TypeOf((*TaskContext)(nil))
 
 type task struct {
 	callback        reflect.Value
 	takesTerminator bool
+	takesContext    bool
+	returnsResult   bool
 }
 
 var taskDefinitions = map[string]task{}
@@ -26,18 +35,27 @@ func RegisterTask(name string, callback interface{}) {
 		panic("task callback must be function")
 	}
 
-	if ctype.NumOut() != 1 || ctype.Out(0) != typeOfError {
-		panic("task callback must return a single error")
+	returnsResult := false
+	switch {
+	case ctype.NumOut() == 1 && ctype.Out(0) == typeOfError:
+	case ctype.NumOut() == 2 && ctype.Out(1) == typeOfError:
+		returnsResult = true
+	default:
+		panic("task callback must return an error, or a result and an error")
 	}
 
 	takesTerminator := false
-	if ctype.NumIn() >= 1 && ctype.In(1) == typeOfTerminator {
-		takesTerminator = true
+	takesContext := false
+	if ctype.NumIn() >= 1 {
+		takesTerminator = ctype.In(0) == typeOfTerminator
+		takesContext = ctype.In(0) == typeOfTaskContext
 	}
 
 	taskDefinitions[name] = task{
 		callback:        cvalue,
 		takesTerminator: takesTerminator,
+		takesContext:    takesContext,
+		returnsResult:   returnsResult,
 	}
 }
 
@@ -54,55 +72,178 @@ func (c Connection) PerformTaskOnQueue(taskName string, parameters []interface{}
 }
 
 func (c Connection) PerformTaskOnQueueAt(taskName string, parameters []interface{}, queueName string, startAt time.Time) (uint, error) {
-	return c.pushJobTo(queueName, taskName, parameters, startAt)
+	job := &PendingJob{
+		TaskName:   taskName,
+		Parameters: parameters,
+		QueueName:  queueName,
+		StartAt:    startAt,
+	}
+
+	return runClientMiddleware(job, func(job *PendingJob) (uint, error) {
+		id, err := c.pushJobTo(job.QueueName, job.TaskName, job.Parameters, job.StartAt)
+		if err != nil {
+			return 0, err
+		}
+
+		c.eventBus().emit(Event{
+			Kind: JobEnqueuedEvent,
+			Job: &Job{
+				ID:         id,
+				QueueName:  job.QueueName,
+				TaskName:   job.TaskName,
+				Parameters: job.Parameters,
+			},
+		})
+
+		return id, nil
+	})
+}
+
+func (c Connection) JobResult(id uint, result interface{}) error {
+	state, blob, err := c.fetchJobResult(id)
+	if err != nil {
+		return err
+	}
+
+	if state != JobFinished {
+		return ErrJobNotFinished
+	}
+
+	if blob == nil {
+		return ErrNoJobResult
+	}
+
+	if err = decodeValue(blob, result); err != nil {
+		return fmt.Errorf("deserialization failure: %v", err)
+	}
+
+	return nil
 }
 
-func performTaskAsync(name string, parameters []interface{}, callback func(error)) (chan struct{}, error) {
-	task, ok := taskDefinitions[name]
+func (c Connection) JobProgress(id uint) (*JobProgress, error) {
+	return c.fetchJobProgress(id)
+}
+
+func (c Connection) JobLogs(id uint) ([]JobLogLine, error) {
+	return c.fetchJobLogs(id)
+}
+
+// ExecuteOptions configures ExecuteJob. The zero value logs to
+// DefaultWorkerLogger and emits events on the Connection's event bus.
+type ExecuteOptions struct {
+	Logger   logrus.FieldLogger
+	EventBus *EventBus
+}
+
+// ExecuteJob runs a job which is already marked as running in the store on
+// the calling goroutine, through the server middleware, and records and
+// reports it as a worker would. It's meant for harnesses such as kigotest
+// which run jobs without a worker. It returns the task's error, if any, or
+// else the error recording its outcome.
+func (c Connection) ExecuteJob(encodedJob EncodedJob, options ExecuteOptions) error {
+	if options.Logger == nil {
+		options.Logger = DefaultWorkerLogger
+	}
+
+	if options.EventBus == nil {
+		options.EventBus = c.eventBus()
+	}
+
+	runner := jobRunner{c: c, log: options.Logger, emit: options.EventBus.emit}
+
+	job, err := encodedJob.Decode()
+	if err != nil {
+		job = &Job{ID: encodedJob.ID, QueueName: encodedJob.QueueName, TaskName: encodedJob.TaskName}
+		runner.reject(job, err, c.failJob(job.ID, err))
+		return err
+	}
+
+	done := make(chan jobOutcome, 1)
+	if err := runner.start(job, nil, func(outcome jobOutcome) { done <- outcome }); err != nil {
+		return err
+	}
+
+	outcome := <-done
+	if err := runner.complete(job, outcome, false); outcome.err == nil {
+		return err
+	}
+	return outcome.err
+}
+
+func performTaskAsync(context *TaskContext, callback func([]byte, error)) (chan struct{}, error) {
+	task, ok := taskDefinitions[context.Job.TaskName]
 	if !ok {
-		return nil, fmt.Errorf("no such task %s", name)
+		return nil, fmt.Errorf("no such task %s", context.Job.TaskName)
 	}
 
 	var terminator chan struct{} = nil
-	if task.takesTerminator {
+	if task.takesTerminator || task.takesContext {
 		terminator = make(chan struct{})
+		context.Terminator = terminator
 	}
 
-	var allValues []reflect.Value
-	var parameterValues []reflect.Value
+	invoke := func() (interface{}, error) {
+		parameters := context.Job.Parameters
+		allValues := make([]reflect.Value, 0, len(parameters)+1)
 
-	if task.takesTerminator {
-		allValues = make([]reflect.Value, len(parameterValues)+1)
-		parameterValues = allValues[1:]
-		allValues[0] = reflect.ValueOf(terminator)
-	} else {
-		allValues = make([]reflect.Value, len(parameterValues))
-		parameterValues = allValues
-	}
+		if task.takesTerminator {
+			allValues = append(allValues, reflect.ValueOf(terminator))
+		} else if task.takesContext {
+			allValues = append(allValues, reflect.ValueOf(context))
+		}
 
-	parameterValues = make([]reflect.Value, len(parameters))
-	for i := 0; i < len(parameters); i++ {
-		parameterValues[i] = reflect.ValueOf(parameters[i])
+		for _, parameter := range parameters {
+			allValues = append(allValues, reflect.ValueOf(parameter))
+		}
+
+		returnValues := task.callback.Call(allValues)
+
+		errorValue := returnValues[len(returnValues)-1]
+		if !errorValue.IsNil() {
+			return nil, errorValue.Interface().(error)
+		}
+
+		if !task.returnsResult || isNilValue(returnValues[0]) {
+			return nil, nil
+		}
+
+		return returnValues[0].Interface(), nil
 	}
 
 	go func() {
+		var result interface{}
 		var err error
 
 		func() {
+			defer context.finish()
 			defer func() {
 				if r := recover(); r != nil {
+					result = nil
 					err = fmt.Errorf("panic: %v", r)
 				}
 			}()
 
-			valueResult := task.callback.Call(allValues)[0]
-			if !valueResult.IsNil() {
-				err = valueResult.Interface().(error)
-			}
+			result, err = runServerMiddleware(context, invoke)
 		}()
 
-		callback(err)
+		var resultBlob []byte
+		if err == nil && result != nil {
+			if resultBlob, err = encodeValue(result); err != nil {
+				err = fmt.Errorf("couldn't encode result: %v", err)
+			}
+		}
+
+		callback(resultBlob, err)
 	}()
 
 	return terminator, nil
 }
+
+func isNilValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return value.IsNil()
+	default:
+		return false
+	}
+}
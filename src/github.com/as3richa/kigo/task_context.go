@@ -0,0 +1,273 @@
+package kigo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const progressReportInterval = 5 * time.Second
+const jobLogFlushInterval = 5 * time.Second
+const jobLogLineLimit = 100
+
+type JobProgress struct {
+	Percent    float64   `json:"percent"`
+	Message    string    `json:"message"`
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+type JobLogLine struct {
+	Level    string                 `json:"level"`
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	LoggedAt time.Time              `json:"loggedAt"`
+}
+
+// TaskContext is passed to tasks whose callback takes a *TaskContext as its
+// first argument. It gives the task access to its job, the terminator channel,
+// a job-scoped logger, and progress reporting.
+type TaskContext struct {
+	Job        *Job
+	Terminator chan struct{}
+	Log        logrus.FieldLogger
+
+	progress struct {
+		sync.Mutex
+		throttle throttle
+		save     func(JobProgress)
+		pending  *JobProgress
+	}
+
+	logs struct {
+		sync.Mutex
+		throttle throttle
+		save     func([]JobLogLine)
+		pending  []JobLogLine
+	}
+}
+
+type taskSinks struct {
+	log          logrus.FieldLogger
+	saveProgress func(JobProgress)
+	saveLogs     func([]JobLogLine)
+}
+
+func newTaskContext(job *Job, sinks taskSinks) *TaskContext {
+	context := &TaskContext{Job: job}
+
+	context.progress.save = sinks.saveProgress
+	context.progress.throttle = throttle{interval: progressReportInterval, flush: context.flushProgress}
+
+	context.logs.save = sinks.saveLogs
+	context.logs.throttle = throttle{interval: jobLogFlushInterval, flush: context.flushLogs}
+
+	logger := logrus.New()
+	logger.Out = nullWriter{}
+	logger.Level = logrus.DebugLevel
+	logger.Hooks.Add(jobLogHook{context: context, parent: sinks.log})
+
+	context.Log = logger.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName})
+
+	return context
+}
+
+// ReportProgress records how far along the task is. Reports are written to the
+// job in the background, at most once per progressReportInterval; in between,
+// only the latest report is kept and it is written once the interval elapses.
+func (tc *TaskContext) ReportProgress(percent float64, message string) {
+	tc.progress.Lock()
+	tc.progress.pending = &JobProgress{
+		Percent:    percent,
+		Message:    message,
+		ReportedAt: time.Now(),
+	}
+	tc.progress.Unlock()
+
+	tc.progress.throttle.trigger()
+}
+
+// flushProgress saves the latest report, if there is one. The report is taken
+// under the lock but saved without it, so that ReportProgress never waits on
+// the store.
+func (tc *TaskContext) flushProgress() {
+	tc.progress.Lock()
+	pending := tc.progress.pending
+	tc.progress.pending = nil
+	tc.progress.Unlock()
+
+	if pending != nil && tc.progress.save != nil {
+		tc.progress.save(*pending)
+	}
+}
+
+// captureLogLine queues a line to be appended to the job's logs. Only the last
+// jobLogLineLimit lines are kept, so older queued lines are dropped.
+func (tc *TaskContext) captureLogLine(line JobLogLine) {
+	tc.logs.Lock()
+	tc.logs.pending = append(tc.logs.pending, line)
+	if len(tc.logs.pending) > jobLogLineLimit {
+		tc.logs.pending = tc.logs.pending[len(tc.logs.pending)-jobLogLineLimit:]
+	}
+	tc.logs.Unlock()
+
+	tc.logs.throttle.trigger()
+}
+
+// flushLogs appends the lines captured since the last flush to the job's
+// logs, likewise without holding the lock.
+func (tc *TaskContext) flushLogs() {
+	tc.logs.Lock()
+	pending := tc.logs.pending
+	tc.logs.pending = nil
+	tc.logs.Unlock()
+
+	if len(pending) > 0 && tc.logs.save != nil {
+		tc.logs.save(pending)
+	}
+}
+
+// finish is called once the task returns, before the job is marked finished
+// or failed. Pending progress and captured log lines are written out, so that
+// the task's last report isn't lost to the throttle.
+func (tc *TaskContext) finish() {
+	tc.progress.throttle.stop(true)
+	tc.logs.throttle.stop(true)
+}
+
+// throttle calls flush at most once per interval; triggers that arrive in
+// between are coalesced into a single deferred flush. Flushes run on a timer
+// goroutine, never in the caller of trigger, and never concurrently.
+type throttle struct {
+	sync.Mutex
+
+	interval time.Duration
+	flush    func()
+
+	flushedAt time.Time
+	timer     *time.Timer
+	flushing  bool
+	stopped   bool
+
+	// inFlight is held for the duration of each flush, so that stop can wait
+	// for one in progress.
+	inFlight sync.Mutex
+}
+
+func (t *throttle) trigger() {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.stopped || t.timer != nil {
+		return
+	}
+
+	wait := t.interval - time.Since(t.flushedAt)
+	if wait < 0 {
+		wait = 0
+	}
+
+	t.timer = time.AfterFunc(wait, t.deferredFlush)
+}
+
+func (t *throttle) deferredFlush() {
+	t.Lock()
+
+	t.timer = nil
+	if t.stopped {
+		t.Unlock()
+		return
+	}
+
+	if t.flushing {
+		// The previous flush is slow; rather than queue up behind it, try
+		// again once another interval has elapsed
+		t.timer = time.AfterFunc(t.interval, t.deferredFlush)
+		t.Unlock()
+		return
+	}
+
+	t.flushing = true
+	t.flushedAt = time.Now()
+	t.inFlight.Lock()
+	t.Unlock()
+
+	t.flush()
+
+	t.Lock()
+	t.flushing = false
+	t.Unlock()
+	t.inFlight.Unlock()
+}
+
+// stop cancels any deferred flush, waits for a flush in progress, and then
+// flushes one last time if a deferred flush was cancelled and flush is set.
+func (t *throttle) stop(flush bool) {
+	t.Lock()
+
+	if t.stopped {
+		t.Unlock()
+		return
+	}
+	t.stopped = true
+
+	pending := t.timer != nil
+	if pending {
+		t.timer.Stop()
+		t.timer = nil
+	}
+
+	t.Unlock()
+
+	t.inFlight.Lock()
+	defer t.inFlight.Unlock()
+
+	if flush && pending {
+		t.flush()
+	}
+}
+
+type jobLogHook struct {
+	context *TaskContext
+	parent  logrus.FieldLogger
+}
+
+func (jobLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h jobLogHook) Fire(entry *logrus.Entry) error {
+	fields := map[string]interface{}{}
+	for key, value := range entry.Data {
+		if key == "id" || key == "taskName" {
+			continue
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		fields[key] = value
+	}
+
+	h.context.captureLogLine(JobLogLine{
+		Level:    entry.Level.String(),
+		Message:  entry.Message,
+		Fields:   fields,
+		LoggedAt: entry.Time,
+	})
+
+	if h.parent != nil {
+		parentEntry := h.parent.WithFields(entry.Data)
+		switch entry.Level {
+		case logrus.DebugLevel:
+			parentEntry.Debug(entry.Message)
+		case logrus.InfoLevel:
+			parentEntry.Info(entry.Message)
+		case logrus.WarnLevel:
+			parentEntry.Warn(entry.Message)
+		default:
+			parentEntry.Error(entry.Message)
+		}
+	}
+
+	return nil
+}
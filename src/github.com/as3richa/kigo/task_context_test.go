@@ -0,0 +1,128 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProgressReportsAreThrottled(t *testing.T) {
+	saved := make(chan JobProgress, 10)
+	context := newTaskContext(&Job{}, taskSinks{
+		saveProgress: func(progress JobProgress) { saved <- progress },
+	})
+
+	context.ReportProgress(10, "first")
+
+	select {
+	case progress := <-saved:
+		if progress.Message != "first" {
+			t.Fatalf("expected the first report to be saved, got %v", progress)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the first report to be saved promptly")
+	}
+
+	context.ReportProgress(20, "second")
+	context.ReportProgress(30, "third")
+
+	if len(saved) != 0 {
+		t.Fatalf("expected later reports to be held back, got %v", <-saved)
+	}
+
+	context.finish()
+
+	if len(saved) != 1 {
+		t.Fatalf("expected the pending report to be flushed on finish, got %d reports", len(saved))
+	}
+	if progress := <-saved; progress.Message != "third" || progress.Percent != 30 {
+		t.Fatalf("expected the latest report to be flushed, got %v", progress)
+	}
+
+	context.ReportProgress(50, "fifth")
+	context.progress.throttle.deferredFlush()
+
+	if len(saved) != 0 {
+		t.Fatalf("expected no reports after finishing, got %v", <-saved)
+	}
+}
+
+func TestJobLogsAreCapturedAndFlushedOnFinish(t *testing.T) {
+	release := make(chan struct{})
+	saved := make(chan []JobLogLine, 10)
+	context := newTaskContext(&Job{ID: 42, TaskName: "testLogs"}, taskSinks{
+		log: NullWorkerLogger,
+		saveLogs: func(lines []JobLogLine) {
+			saved <- lines
+			<-release
+		},
+	})
+
+	context.Log.Info("starting")
+
+	var first []JobLogLine
+	select {
+	case first = <-saved:
+	case <-time.After(time.Second):
+		t.Fatal("expected the first line to be saved promptly")
+	}
+
+	if len(first) != 1 || first[0].Message != "starting" {
+		t.Fatalf("expected only the first line to be saved so far, got %v", first)
+	}
+
+	// The first save is still in progress, but logging mustn't wait for it
+	logged := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*jobLogLineLimit; i++ {
+			context.Log.WithField("i", i).Debug("working")
+		}
+		context.Log.WithError(errors.New("augh")).Warn("done")
+		close(logged)
+	}()
+
+	select {
+	case <-logged:
+	case <-time.After(time.Second):
+		t.Fatal("expected logging not to block on a save in progress")
+	}
+
+	close(release)
+	context.finish()
+
+	if len(saved) != 1 {
+		t.Fatalf("expected one more save on finish, got %d", len(saved))
+	}
+
+	lines := <-saved
+	if len(lines) != jobLogLineLimit {
+		t.Fatalf("expected the last %d lines to be saved on finish, got %d", jobLogLineLimit, len(lines))
+	}
+
+	last := lines[jobLogLineLimit-1]
+	if last.Level != "warning" || last.Message != "done" || last.Fields["error"] != "augh" {
+		t.Errorf("unexpected final line %v", last)
+	}
+
+	if _, ok := last.Fields["id"]; ok {
+		t.Errorf("expected job fields to be stripped from captured lines, got %v", last.Fields)
+	}
+}
+
+func TestTaskTakesContext(t *testing.T) {
+	RegisterTask("testContext", func(context *TaskContext, n int) (string, error) {
+		if context.Terminator == nil {
+			t.Error("expected context to carry a terminator")
+		}
+		return context.Job.TaskName, nil
+	})
+
+	outcome := performTaskSync(t, "testContext", []interface{}{1})
+	expectSuccess(t, outcome.err)
+
+	var name string
+	expectSuccess(t, decodeValue(outcome.result, &name))
+	if name != "testContext" {
+		t.Errorf("expected %v, got %v", "testContext", name)
+	}
+}
@@ -0,0 +1,93 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type taskOutcome struct {
+	result []byte
+	err    error
+}
+
+func performTaskSync(t *testing.T, name string, parameters []interface{}) taskOutcome {
+	outcomes := make(chan taskOutcome, 1)
+
+	_, err := performTaskAsync(newTaskContext(&Job{TaskName: name, Parameters: parameters}, taskSinks{}), func(result []byte, err error) {
+		outcomes <- taskOutcome{result, err}
+	})
+	expectSuccess(t, err)
+
+	return <-outcomes
+}
+
+func TestTaskReceivesParameters(t *testing.T) {
+	RegisterTask("testSum", func(a int, b int) (int, error) { return a + b, nil })
+
+	outcome := performTaskSync(t, "testSum", []interface{}{2, 3})
+	expectSuccess(t, outcome.err)
+
+	var sum int
+	expectSuccess(t, decodeValue(outcome.result, &sum))
+	if sum != 5 {
+		t.Errorf("expected %v, got %v", 5, sum)
+	}
+}
+
+func TestTaskWithoutResult(t *testing.T) {
+	RegisterTask("testNothing", func() error { return nil })
+
+	outcome := performTaskSync(t, "testNothing", nil)
+	expectSuccess(t, outcome.err)
+	if outcome.result != nil {
+		t.Errorf("expected no result, got %v", outcome.result)
+	}
+}
+
+func TestTaskErrorsAndPanics(t *testing.T) {
+	failure := errors.New("augh")
+	RegisterTask("testFail", func() (string, error) { return "", failure })
+	RegisterTask("testPanic", func() error { panic("augh") })
+
+	if outcome := performTaskSync(t, "testFail", nil); outcome.err != failure || outcome.result != nil {
+		t.Errorf("expected %v with no result, got %v and %v", failure, outcome.err, outcome.result)
+	}
+
+	if outcome := performTaskSync(t, "testPanic", nil); outcome.err == nil {
+		t.Error("expected panic to be reported as an error")
+	}
+}
+
+func TestTaskTakesTerminator(t *testing.T) {
+	RegisterTask("testTerminator", func(terminator chan struct{}, n int) (int, error) { return n, nil })
+
+	outcome := performTaskSync(t, "testTerminator", []interface{}{7})
+	expectSuccess(t, outcome.err)
+
+	var n int
+	expectSuccess(t, decodeValue(outcome.result, &n))
+	if n != 7 {
+		t.Errorf("expected %v, got %v", 7, n)
+	}
+}
+
+func TestJobResultRoundTrip(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		id, err := c.pushJobTo("default", "testSum", []interface{}{1, 2}, time.Now())
+		expectSuccess(t, err)
+
+		var sum int
+		if err := c.JobResult(id, &sum); err != ErrJobNotFinished {
+			t.Fatalf("expected %v, got %v", ErrJobNotFinished, err)
+		}
+
+		result, _ := encodeValue(3)
+		markRunning(t, c, id)
+		expectSuccess(t, c.finishJob(id, result))
+		expectSuccess(t, c.JobResult(id, &sum))
+		if sum != 3 {
+			t.Errorf("expected %v, got %v", 3, sum)
+		}
+	})
+}
@@ -18,7 +18,7 @@ func main() {
   }
 
   if url == "" {
-    fmt.Println("Pass a postgres URL via command-line args or the KIGO_URL environment variable")
+    fmt.Println("Pass a Postgres, mysql:// or sqlite:// URL via command-line args or the KIGO_URL environment variable")
     os.Exit(1)
   }
 
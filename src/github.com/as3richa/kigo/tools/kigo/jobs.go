@@ -0,0 +1,434 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "os"
+  "fmt"
+  "flag"
+  "net/http"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+  "time"
+
+  "github.com/as3richa/kigo"
+)
+
+var errEmptyFilter = errors.New("refusing to act on every job; pass -all to do so")
+
+type filterFlags struct {
+  queue  *string
+  task   *string
+  state  *string
+  after  *string
+  before *string
+  all    *bool
+}
+
+func addFilterFlags(flags *flag.FlagSet, bulk bool) *filterFlags {
+  f := &filterFlags{
+    queue:  flags.String("queue", "", "only jobs on this queue"),
+    task:   flags.String("task", "", "only jobs of this task"),
+    state:  flags.String("state", "", "only jobs in this state (enqueued, running, failed or finished)"),
+    after:  flags.String("after", "", "only jobs enqueued after this time (RFC 3339, or a duration ago such as 2h)"),
+    before: flags.String("before", "", "only jobs enqueued before this time (RFC 3339, or a duration ago such as 2h)"),
+  }
+  if bulk {
+    f.all = flags.Bool("all", false, "act on every job when no other filter is given")
+  }
+  return f
+}
+
+func (f *filterFlags) empty() bool {
+  return *f.queue == "" && *f.task == "" && *f.state == "" && *f.after == "" && *f.before == ""
+}
+
+func (f *filterFlags) filter() (kigo.JobFilter, error) {
+  filter := kigo.JobFilter{QueueName: *f.queue, TaskName: *f.task}
+
+  if *f.state != "" {
+    state, err := kigo.ParseJobState(*f.state)
+    if err != nil {
+      return filter, err
+    }
+    filter.State = &state
+  }
+
+  var err error
+  if filter.EnqueuedAfter, err = parseTime(*f.after); err != nil {
+    return filter, err
+  }
+  if filter.EnqueuedBefore, err = parseTime(*f.before); err != nil {
+    return filter, err
+  }
+
+  return filter, nil
+}
+
+// parseTime accepts an RFC 3339 timestamp, or a duration to count back from
+// now. The empty string yields the zero time, which the job filters ignore.
+func parseTime(value string) (time.Time, error) {
+  if value == "" {
+    return time.Time{}, nil
+  }
+
+  if d, err := time.ParseDuration(value); err == nil {
+    return time.Now().Add(-d), nil
+  }
+
+  t, err := time.Parse(time.RFC3339, value)
+  if err != nil {
+    return t, fmt.Errorf("couldn't parse %q as a time or duration", value)
+  }
+  return t, nil
+}
+
+func parseJobIDs(args []string) ([]uint, error) {
+  ids := make([]uint, len(args))
+  for i, arg := range args {
+    id, err := strconv.ParseUint(arg, 10, 0)
+    if err != nil || id == 0 {
+      return nil, fmt.Errorf("%q isn't a job ID", arg)
+    }
+    ids[i] = uint(id)
+  }
+  return ids, nil
+}
+
+func listJobs(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("jobs", "[FILTER] [-limit N] [-cursor ID]")
+  f := addFilterFlags(flags, false)
+  limit := flags.Uint("limit", 50, "maximum number of jobs to list")
+  cursor := flags.Uint("cursor", 0, "list jobs older than this ID, as printed after the previous page")
+  flags.Parse(args)
+
+  filter, err := f.filter()
+  if err != nil {
+    return err
+  }
+
+  page, err := connection.ListJobs(filter, *cursor, *limit)
+  if err != nil {
+    return err
+  }
+
+  if jsonOutput {
+    return printJSON(page)
+  }
+
+  rows := make([][]string, len(page.Jobs))
+  for i, job := range page.Jobs {
+    errorMessage := "-"
+    if job.Error != nil {
+      errorMessage = truncate(*job.Error, 60)
+    }
+
+    rows[i] = []string{
+      fmt.Sprint(job.ID),
+      job.QueueName,
+      job.TaskName,
+      job.State.String(),
+      formatTime(&job.EnqueuedAt),
+      formatTime(&job.StartAt),
+      formatTime(job.FinishedAt),
+      errorMessage,
+    }
+  }
+
+  if err := printTable([]string{"ID", "QUEUE", "TASK", "STATE", "ENQUEUED", "START AT", "FINISHED", "ERROR"}, rows); err != nil {
+    return err
+  }
+
+  if page.Next != 0 {
+    fmt.Printf("\nMore jobs follow; pass -cursor %d for the next page\n", page.Next)
+  }
+  return nil
+}
+
+type jobDetails struct {
+  *kigo.JobInfo
+  Logs []kigo.JobLogLine `json:"logs"`
+}
+
+func showJob(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("job", "ID")
+  flags.Parse(args)
+
+  if flags.NArg() != 1 {
+    flags.Usage()
+    os.Exit(2)
+  }
+
+  ids, err := parseJobIDs(flags.Args())
+  if err != nil {
+    return err
+  }
+
+  job, err := connection.FindJob(ids[0])
+  if err != nil {
+    return err
+  }
+
+  logs, err := connection.JobLogs(job.ID)
+  if err != nil {
+    return err
+  }
+
+  if jsonOutput {
+    return printJSON(jobDetails{JobInfo: job, Logs: logs})
+  }
+
+  parameters, err := json.Marshal(job.Parameters)
+  if err != nil {
+    parameters = []byte(fmt.Sprint(job.Parameters))
+  }
+
+  writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+  field := func(name string, value string) {
+    fmt.Fprintf(writer, "%s:\t%s\n", name, value)
+  }
+
+  field("ID", fmt.Sprint(job.ID))
+  field("Queue", job.QueueName)
+  field("Task", job.TaskName)
+  field("Parameters", string(parameters))
+  field("State", job.State.String())
+  field("Enqueued", formatTime(&job.EnqueuedAt))
+  field("Start at", formatTime(&job.StartAt))
+  field("Started", formatTime(job.StartedAt))
+  field("Finished", formatTime(job.FinishedAt))
+
+  if job.WorkerID != nil {
+    field("Worker", fmt.Sprintf("%s (%d)", job.WorkerName, *job.WorkerID))
+  }
+  if job.Progress != nil {
+    field("Progress", fmt.Sprintf("%.0f%% %s (at %s)", job.Progress.Percent, job.Progress.Message, formatTime(&job.Progress.ReportedAt)))
+  }
+  if job.Error != nil {
+    field("Error", *job.Error)
+  }
+
+  if err := writer.Flush(); err != nil {
+    return err
+  }
+
+  if len(logs) != 0 {
+    fmt.Println("\nLogs:")
+    for _, line := range logs {
+      fmt.Printf("  %s %-5s %s%s\n", formatTime(&line.LoggedAt), line.Level, line.Message, formatFields(line.Fields))
+    }
+  }
+
+  return nil
+}
+
+func formatFields(fields map[string]interface{}) string {
+  formatted := ""
+  for key, value := range fields {
+    formatted += fmt.Sprintf(" %s=%v", key, value)
+  }
+  return formatted
+}
+
+func truncate(s string, length int) string {
+  s = strings.Replace(s, "\n", " ", -1)
+  if len(s) <= length {
+    return s
+  }
+  return s[:length-3] + "..."
+}
+
+// jobAction is a retry, cancel or delete, applied either to the jobs named on
+// the command line or to those matching a filter.
+type jobAction struct {
+  name string
+  done string
+  one  func(id uint) error
+  many func(filter kigo.JobFilter) (uint, error)
+}
+
+type jobActionFailure struct {
+  ID    uint   `json:"id"`
+  Error string `json:"error"`
+}
+
+type jobActionResult struct {
+  Count    uint               `json:"count"`
+  Failures []jobActionFailure `json:"failures,omitempty"`
+}
+
+func runJobAction(action jobAction, flags *flag.FlagSet, f *filterFlags, args []string) error {
+  positional := parseInterspersed(flags, args)
+
+  var result jobActionResult
+
+  if len(positional) != 0 {
+    if !f.empty() {
+      return errors.New("pass either job IDs or a filter, not both")
+    }
+
+    ids, err := parseJobIDs(positional)
+    if err != nil {
+      return err
+    }
+
+    for _, id := range ids {
+      if err := action.one(id); err != nil {
+        result.Failures = append(result.Failures, jobActionFailure{ID: id, Error: err.Error()})
+      } else {
+        result.Count++
+      }
+    }
+  } else {
+    if f.empty() && !*f.all {
+      return errEmptyFilter
+    }
+
+    filter, err := f.filter()
+    if err != nil {
+      return err
+    }
+
+    if result.Count, err = action.many(filter); err != nil {
+      return err
+    }
+  }
+
+  if err := report(result, "%s %d job(s)", action.done, result.Count); err != nil {
+    return err
+  }
+
+  if len(result.Failures) != 0 {
+    if !jsonOutput {
+      for _, failure := range result.Failures {
+        fmt.Fprintf(os.Stderr, "Couldn't %s job %d: %s\n", action.name, failure.ID, failure.Error)
+      }
+    }
+    return fmt.Errorf("%d job(s) couldn't be %s", len(result.Failures), strings.ToLower(action.done))
+  }
+
+  return nil
+}
+
+func retryJobs(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("retry", "[FILTER [-all] | ID...]")
+  f := addFilterFlags(flags, true)
+
+  return runJobAction(jobAction{
+    name: "retry",
+    done: "Retried",
+    one:  connection.RetryJob,
+    many: connection.RetryJobs,
+  }, flags, f, args)
+}
+
+func deleteJobs(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("delete", "[FILTER [-all] | ID...]")
+  f := addFilterFlags(flags, true)
+
+  return runJobAction(jobAction{
+    name: "delete",
+    done: "Deleted",
+    one:  connection.DeleteJob,
+    many: connection.DeleteJobs,
+  }, flags, f, args)
+}
+
+func cancelJobs(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("cancel", "[FILTER [-all] | ID...] [-worker ADDR [-worker-token TOKEN]]")
+  f := addFilterFlags(flags, true)
+  workerAddr := flags.String("worker", "", "API address (host:port) of the worker running the jobs, to cancel running jobs by ID")
+  workerToken := flags.String("worker-token", os.Getenv("KIGO_WORKER_TOKEN"), "bearer token for the worker's API, if it requires one")
+
+  cancel := func(id uint) error {
+    err := connection.CancelJob(id)
+    if err == kigo.ErrJobNotEnqueued && *workerAddr != "" {
+      return cancelRunningJob(*workerAddr, *workerToken, id)
+    }
+    return err
+  }
+
+  return runJobAction(jobAction{
+    name: "cancel",
+    done: "Cancelled",
+    one:  cancel,
+    many: connection.CancelJobs,
+  }, flags, f, args)
+}
+
+// cancelRunningJob asks a worker, through its API, to cancel a job it's
+// running. The token is sent as a bearer token unless it's empty.
+func cancelRunningJob(addr string, token string, id uint) error {
+  request, err := http.NewRequest("POST", fmt.Sprintf("http://%s/api/jobs/%d/cancel", addr, id), nil)
+  if err != nil {
+    return err
+  }
+  request.Header.Set("Content-Type", "application/json")
+  if token != "" {
+    request.Header.Set("Authorization", "Bearer "+token)
+  }
+
+  client := http.Client{Timeout: 10 * time.Second}
+  response, err := client.Do(request)
+  if err != nil {
+    return err
+  }
+  defer response.Body.Close()
+
+  if response.StatusCode == http.StatusOK {
+    return nil
+  }
+
+  var body struct {
+    Error string `json:"error"`
+  }
+  if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error == "" {
+    return fmt.Errorf("worker responded with %s", response.Status)
+  }
+  return errors.New(body.Error)
+}
+
+type enqueueResult struct {
+  ID uint `json:"id"`
+}
+
+func enqueueJob(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("enqueue", "[-queue NAME] [-at TIME | -in DURATION] TASK [JSON_ARGS]")
+  queueName := flags.String("queue", "default", "queue to enqueue the job on")
+  at := flags.String("at", "", "time to run the job at (RFC 3339)")
+  in := flags.Duration("in", 0, "delay before the job runs")
+  flags.Parse(args)
+
+  if flags.NArg() < 1 || flags.NArg() > 2 {
+    flags.Usage()
+    os.Exit(2)
+  }
+
+  startAt := time.Now().Add(*in)
+  if *at != "" {
+    if *in != 0 {
+      return errors.New("pass at most one of -at and -in")
+    }
+
+    var err error
+    if startAt, err = time.Parse(time.RFC3339, *at); err != nil {
+      return err
+    }
+  }
+
+  parameters := []interface{}{}
+  if flags.NArg() == 2 {
+    var err error
+    if parameters, err = parseParameters(flags.Arg(1)); err != nil {
+      return err
+    }
+  }
+
+  id, err := connection.PerformTaskOnQueueAt(flags.Arg(0), parameters, *queueName, startAt)
+  if err != nil {
+    return err
+  }
+
+  return report(enqueueResult{ID: id}, "Enqueued job %d", id)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+  "encoding/json"
+  "os"
+  "fmt"
+  "flag"
+  "strings"
+  "text/tabwriter"
+
+  "github.com/as3richa/kigo"
+)
+
+const usage = `Usage: kigo [-url URL] [-json] COMMAND [ARGS]
+
+Schema:
+  migrate [-to VERSION] [-sql]     migrate the schema up (or down) to VERSION
+  rollback [-to VERSION] [-sql]    roll the schema back, by one version by default
+  status                           show the schema version
+
+Queues and workers:
+  queues                           list queues with job counts
+  workers                          list workers and the jobs they're running
+
+Jobs:
+  jobs [FILTER] [-limit N] [-cursor ID]   list jobs, newest first
+  job ID                                  show a job, its progress and its logs
+  retry [FILTER | ID...]                  retry failed jobs
+  cancel [FILTER | ID...] [-worker ADDR]  cancel enqueued jobs, or running jobs via a worker's API
+  delete [FILTER | ID...]                 delete jobs which aren't running
+  enqueue [-queue NAME] [-at TIME | -in DURATION] TASK [JSON_ARGS]
+                                          enqueue a job, e.g. enqueue SendEmail '[42, "welcome"]'
+
+FILTER is any of -queue, -task, -state, -after and -before; bulk actions on
+every job also need -all. The URL defaults to the KIGO_URL environment
+variable, and cancel's -worker-token to KIGO_WORKER_TOKEN. Run kigo COMMAND -h
+for a command's flags.
+`
+
+type command func(connection kigo.Connection, args []string) error
+
+var commands = map[string]command{
+  "migrate":  migrate,
+  "rollback": rollback,
+  "status":   status,
+  "queues":   listQueues,
+  "workers":  listWorkers,
+  "jobs":     listJobs,
+  "job":      showJob,
+  "retry":    retryJobs,
+  "cancel":   cancelJobs,
+  "delete":   deleteJobs,
+  "enqueue":  enqueueJob,
+}
+
+// jsonOutput selects machine-readable output: each command prints a single
+// JSON document instead of a table.
+var jsonOutput bool
+
+func main() {
+  url := flag.String("url", os.Getenv("KIGO_URL"), "Postgres, mysql:// or sqlite:// URL of the kigo database")
+  flag.BoolVar(&jsonOutput, "json", false, "print JSON instead of tables")
+  flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+  flag.Parse()
+
+  if flag.NArg() == 0 {
+    flag.Usage()
+    os.Exit(2)
+  }
+
+  run, ok := commands[flag.Arg(0)]
+  if !ok {
+    fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+    flag.Usage()
+    os.Exit(2)
+  }
+
+  if *url == "" {
+    fmt.Fprintln(os.Stderr, "Pass a Postgres, mysql:// or sqlite:// URL via -url or the KIGO_URL environment variable")
+    os.Exit(1)
+  }
+
+  connection, err := kigo.Connect(*url)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Couldn't connect to kigo: %v\n", err)
+    os.Exit(1)
+  }
+
+  err = run(connection, flag.Args()[1:])
+  connection.Close()
+
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "kigo %s: %v\n", flag.Arg(0), err)
+    os.Exit(1)
+  }
+}
+
+func newFlagSet(name string, arguments string) *flag.FlagSet {
+  flags := flag.NewFlagSet(name, flag.ExitOnError)
+  flags.Usage = func() {
+    fmt.Fprintf(os.Stderr, "Usage: kigo %s %s\n", name, arguments)
+    flags.PrintDefaults()
+  }
+  return flags
+}
+
+// parseInterspersed parses flags wherever they appear among args, unlike
+// FlagSet.Parse, which stops at the first positional argument, and returns the
+// positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+  var positional []string
+  flags.Parse(args)
+  for flags.NArg() != 0 {
+    positional = append(positional, flags.Arg(0))
+    flags.Parse(flags.Args()[1:])
+  }
+  return positional
+}
+
+func printJSON(value interface{}) error {
+  encoder := json.NewEncoder(os.Stdout)
+  encoder.SetIndent("", "  ")
+  return encoder.Encode(value)
+}
+
+// printTable prints tab-aligned rows under the given header.
+func printTable(header []string, rows [][]string) error {
+  writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+  fmt.Fprintln(writer, strings.Join(header, "\t"))
+  for _, row := range rows {
+    fmt.Fprintln(writer, strings.Join(row, "\t"))
+  }
+  return writer.Flush()
+}
+
+// report prints value as JSON, or message for humans.
+func report(value interface{}, format string, args ...interface{}) error {
+  if jsonOutput {
+    return printJSON(value)
+  }
+  fmt.Printf(format+"\n", args...)
+  return nil
+}
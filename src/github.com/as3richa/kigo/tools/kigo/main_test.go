@@ -0,0 +1,22 @@
+package main
+
+import (
+  "flag"
+  "reflect"
+  "testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+  flags := flag.NewFlagSet("cancel", flag.ContinueOnError)
+  worker := flags.String("worker", "", "")
+  all := flags.Bool("all", false, "")
+
+  positional := parseInterspersed(flags, []string{"1", "2", "-worker", "localhost:9000", "3", "-all"})
+
+  if expected := []string{"1", "2", "3"}; !reflect.DeepEqual(positional, expected) {
+    t.Errorf("expected %v, got %v", expected, positional)
+  }
+  if *worker != "localhost:9000" || !*all {
+    t.Errorf("expected flags after the IDs to be parsed, got -worker %q -all %v", *worker, *all)
+  }
+}
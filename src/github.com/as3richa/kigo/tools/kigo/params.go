@@ -0,0 +1,117 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+)
+
+// parseParameters decodes a JSON array of job parameters into the types that
+// tasks receive: integers become int, other numbers float64, and arrays
+// slices of their elements' type. Parameters are gob-encoded, so only types
+// gob knows about without registration are supported; objects, nulls, nested
+// arrays and empty arrays (whose element type is unknown) are rejected.
+func parseParameters(text string) ([]interface{}, error) {
+  decoder := json.NewDecoder(bytes.NewBufferString(text))
+  decoder.UseNumber()
+
+  var values []interface{}
+  if err := decoder.Decode(&values); err != nil {
+    return nil, fmt.Errorf("parameters must be a JSON array: %v", err)
+  }
+
+  parameters := make([]interface{}, len(values))
+  for i, value := range values {
+    parameter, err := convertParameter(value, true)
+    if err != nil {
+      return nil, fmt.Errorf("parameter %d: %v", i, err)
+    }
+    parameters[i] = parameter
+  }
+  return parameters, nil
+}
+
+func convertParameter(value interface{}, allowArrays bool) (interface{}, error) {
+  switch value := value.(type) {
+  case string, bool:
+    return value, nil
+  case json.Number:
+    if n, err := value.Int64(); err == nil {
+      return int(n), nil
+    }
+    return value.Float64()
+  case []interface{}:
+    if !allowArrays {
+      return nil, errors.New("nested arrays aren't supported")
+    }
+    return convertArray(value)
+  case nil:
+    return nil, errors.New("null isn't supported")
+  default:
+    return nil, errors.New("objects aren't supported")
+  }
+}
+
+func convertArray(values []interface{}) (interface{}, error) {
+  if len(values) == 0 {
+    return nil, errors.New("empty arrays aren't supported, since their element type is unknown")
+  }
+
+  elements := make([]interface{}, len(values))
+  for i, value := range values {
+    element, err := convertParameter(value, false)
+    if err != nil {
+      return nil, err
+    }
+    elements[i] = element
+  }
+
+  switch elements[0].(type) {
+  case string:
+    strs := make([]string, len(elements))
+    for i, element := range elements {
+      s, ok := element.(string)
+      if !ok {
+        return nil, errors.New("arrays must not mix strings with other types")
+      }
+      strs[i] = s
+    }
+    return strs, nil
+
+  case bool:
+    bools := make([]bool, len(elements))
+    for i, element := range elements {
+      b, ok := element.(bool)
+      if !ok {
+        return nil, errors.New("arrays must not mix booleans with other types")
+      }
+      bools[i] = b
+    }
+    return bools, nil
+  }
+
+  // Numbers: an array of integers is []int, but a single non-integer makes
+  // it []float64
+  ints := make([]int, len(elements))
+  floats := make([]float64, len(elements))
+  integral := true
+
+  for i, element := range elements {
+    switch n := element.(type) {
+    case int:
+      ints[i] = n
+      floats[i] = float64(n)
+    case float64:
+      floats[i] = n
+      integral = false
+    default:
+      return nil, errors.New("arrays must not mix numbers with other types")
+    }
+  }
+
+  if integral {
+    return ints, nil
+  }
+  return floats, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestParseParameters(t *testing.T) {
+  parameters, err := parseParameters(`[42, 1.5, "welcome", true, [1, 2], [1, 2.5], ["a", "b"], [false]]`)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  expected := []interface{}{42, 1.5, "welcome", true, []int{1, 2}, []float64{1, 2.5}, []string{"a", "b"}, []bool{false}}
+  if !reflect.DeepEqual(parameters, expected) {
+    t.Errorf("expected %#v, got %#v", expected, parameters)
+  }
+
+  for _, text := range []string{`{"a": 1}`, `[{"a": 1}]`, `[null]`, `[[]]`, `[[[1]]]`, `[[1, "a"]]`, `[["a", 1]]`} {
+    if _, err := parseParameters(text); err == nil {
+      t.Errorf("expected %s to be rejected", text)
+    }
+  }
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "strings"
+
+  "github.com/as3richa/kigo"
+)
+
+type schemaStatus struct {
+  Version uint `json:"version"`
+  Latest  uint `json:"latest"`
+}
+
+type migrationResult struct {
+  From       uint     `json:"from"`
+  To         uint     `json:"to"`
+  Statements []string `json:"statements,omitempty"`
+}
+
+func migrate(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("migrate", "[-to VERSION] [-sql]")
+  to := flags.Uint("to", kigo.LatestSchemaVersion, "schema version to migrate up or down to")
+  printSQL := flags.Bool("sql", false, "print the pending statements instead of executing them")
+  flags.Parse(args)
+
+  return migrateTo(connection, *to, *printSQL)
+}
+
+func rollback(connection kigo.Connection, args []string) error {
+  flags := newFlagSet("rollback", "[-to VERSION] [-sql]")
+  to := flags.Int("to", -1, "schema version to roll back to (default: the previous version)")
+  printSQL := flags.Bool("sql", false, "print the pending statements instead of executing them")
+  flags.Parse(args)
+
+  version, err := connection.SchemaVersion()
+  if err != nil {
+    return err
+  }
+
+  if *to < 0 {
+    if version == 0 {
+      return errors.New("there is no schema to roll back")
+    }
+    *to = int(version) - 1
+  } else if uint(*to) > version {
+    return fmt.Errorf("version %d is newer than the current version %d; use migrate instead", *to, version)
+  }
+
+  return migrateTo(connection, uint(*to), *printSQL)
+}
+
+func migrateTo(connection kigo.Connection, to uint, printSQL bool) error {
+  from, err := connection.SchemaVersion()
+  if err != nil {
+    return err
+  }
+
+  if printSQL {
+    statements, err := connection.MigrationSQL(to)
+    if err != nil {
+      return err
+    }
+
+    if jsonOutput {
+      return printJSON(migrationResult{From: from, To: to, Statements: statements})
+    }
+
+    for _, statement := range statements {
+      fmt.Println(strings.TrimSpace(statement) + ";")
+      fmt.Println()
+    }
+    return nil
+  }
+
+  if err := connection.MigrateTo(to); err != nil {
+    return err
+  }
+
+  return report(migrationResult{From: from, To: to}, "Migrated from version %d to version %d", from, to)
+}
+
+func status(connection kigo.Connection, args []string) error {
+  newFlagSet("status", "").Parse(args)
+
+  version, err := connection.SchemaVersion()
+  if err != nil {
+    return err
+  }
+
+  result := schemaStatus{Version: version, Latest: kigo.LatestSchemaVersion}
+  switch {
+  case version < kigo.LatestSchemaVersion:
+    return report(result, "Schema version %d; %d migration(s) pending, up to version %d", version, kigo.LatestSchemaVersion-version, kigo.LatestSchemaVersion)
+  case version > kigo.LatestSchemaVersion:
+    return report(result, "Schema version %d, which is newer than this tool's latest version %d", version, kigo.LatestSchemaVersion)
+  default:
+    return report(result, "Schema version %d (up to date)", version)
+  }
+}
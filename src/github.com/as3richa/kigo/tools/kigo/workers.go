@@ -0,0 +1,103 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "time"
+
+  "github.com/as3richa/kigo"
+)
+
+type queueRow struct {
+  Name        string  `json:"name"`
+  Enqueued    uint    `json:"enqueued"`
+  Scheduled   uint    `json:"scheduled"`
+  Running     uint    `json:"running"`
+  Failed      uint    `json:"failed"`
+  Finished    uint    `json:"finished"`
+  Latency     float64 `json:"latencySeconds"`
+  LiveWorkers uint    `json:"liveWorkers"`
+}
+
+func listQueues(connection kigo.Connection, args []string) error {
+  newFlagSet("queues", "").Parse(args)
+
+  stats, err := connection.QueueStats()
+  if err != nil {
+    return err
+  }
+
+  queues := make([]queueRow, len(stats))
+  for i, queue := range stats {
+    queues[i] = queueRow{
+      Name:        queue.Name,
+      Enqueued:    queue.Counts[kigo.JobEnqueued],
+      Scheduled:   queue.Scheduled,
+      Running:     queue.Counts[kigo.JobRunning],
+      Failed:      queue.Counts[kigo.JobFailed],
+      Finished:    queue.Counts[kigo.JobFinished],
+      Latency:     queue.Latency.Seconds(),
+      LiveWorkers: queue.LiveWorkers,
+    }
+  }
+
+  if jsonOutput {
+    return printJSON(queues)
+  }
+
+  rows := make([][]string, len(queues))
+  for i, queue := range queues {
+    rows[i] = []string{
+      queue.Name,
+      fmt.Sprint(queue.Enqueued),
+      fmt.Sprint(queue.Scheduled),
+      fmt.Sprint(queue.Running),
+      fmt.Sprint(queue.Failed),
+      fmt.Sprint(queue.Finished),
+      stats[i].Latency.Round(time.Millisecond).String(),
+      fmt.Sprint(queue.LiveWorkers),
+    }
+  }
+
+  return printTable([]string{"QUEUE", "ENQUEUED", "SCHEDULED", "RUNNING", "FAILED", "FINISHED", "LATENCY", "WORKERS"}, rows)
+}
+
+func listWorkers(connection kigo.Connection, args []string) error {
+  newFlagSet("workers", "").Parse(args)
+
+  workers, err := connection.Workers()
+  if err != nil {
+    return err
+  }
+
+  if jsonOutput {
+    return printJSON(workers)
+  }
+
+  rows := make([][]string, len(workers))
+  for i, worker := range workers {
+    jobIDs := make([]string, len(worker.Jobs))
+    for j, job := range worker.Jobs {
+      jobIDs[j] = fmt.Sprint(job.ID)
+    }
+
+    rows[i] = []string{
+      fmt.Sprint(worker.ID),
+      worker.Name,
+      string(worker.Status),
+      strings.Join(worker.Queues, ","),
+      fmt.Sprintf("%d/%d", len(worker.Jobs), worker.Concurrency),
+      formatTime(&worker.HeartbeatAt),
+      strings.Join(jobIDs, ","),
+    }
+  }
+
+  return printTable([]string{"ID", "NAME", "STATUS", "QUEUES", "BUSY", "HEARTBEAT", "JOBS"}, rows)
+}
+
+func formatTime(t *time.Time) string {
+  if t == nil {
+    return "-"
+  }
+  return t.Local().Format("2006-01-02 15:04:05")
+}
@@ -1,22 +1,40 @@
 package main
 
 import (
+  "flag"
   "os"
   "fmt"
+  "strings"
 
   "github.com/as3richa/kigo"
 )
 
 func main() {
+  to := flag.Uint("to", kigo.LatestSchemaVersion, "schema version to migrate up or down to")
+  printSQL := flag.Bool("sql", false, "print the pending statements instead of executing them")
+  dialect := flag.String("dialect", "", "print the statements for this dialect (postgres, mysql or sqlite3) from version -from, without connecting")
+  from := flag.Uint("from", 0, "schema version to start from with -dialect")
+  flag.Parse()
+
+  if *dialect != "" {
+    statements, err := kigo.MigrationSQL(*dialect, *from, *to)
+    if err != nil {
+      fmt.Printf("Couldn't generate migration: %v\n", err)
+      os.Exit(1)
+    }
+    printStatements(statements)
+    return
+  }
+
   var url string
-  if len(os.Args) > 1 {
-    url = os.Args[1]
+  if flag.NArg() > 0 {
+    url = flag.Arg(0)
   } else {
     url = os.Getenv("KIGO_URL")
   }
 
   if url == "" {
-    fmt.Println("Pass a postgres URL via command-line args or the KIGO_URL environment variable")
+    fmt.Println("Pass a Postgres, mysql:// or sqlite:// URL via command-line args or the KIGO_URL environment variable")
     os.Exit(1)
   }
 
@@ -26,10 +44,27 @@ func main() {
     os.Exit(1)
   }
 
-  if err = connection.Migrate(); err != nil {
+  if *printSQL {
+    statements, err := connection.MigrationSQL(*to)
+    if err != nil {
+      fmt.Printf("Couldn't generate migration: %v\n", err)
+      os.Exit(1)
+    }
+    printStatements(statements)
+    return
+  }
+
+  if err = connection.MigrateTo(*to); err != nil {
     fmt.Printf("Couldn't perform migration: %v\n", err)
     os.Exit(1)
   }
 
-  fmt.Println("Migrated")
+  fmt.Printf("Migrated to version %d\n", *to)
+}
+
+func printStatements(statements []string) {
+  for _, statement := range statements {
+    fmt.Println(strings.TrimSpace(statement) + ";")
+    fmt.Println()
+  }
 }
@@ -0,0 +1,154 @@
+package kigo
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const jobDoneChannel = "kigo_job_done"
+
+const waitPollingInterval = 1 * time.Second
+const listenerMinReconnectInterval = 1 * time.Second
+const listenerMaxReconnectInterval = 30 * time.Second
+
+var ErrWaitTimeout = errors.New("timed out waiting for job")
+
+type JobOutcome struct {
+	ID    uint
+	State JobState
+	Error error
+}
+
+type jobWaiters struct {
+	sync.Mutex
+
+	listener *pq.Listener
+	closed   bool
+	waiting  map[uint]map[chan struct{}]bool
+}
+
+func newJobWaiters() *jobWaiters {
+	return &jobWaiters{waiting: map[uint]map[chan struct{}]bool{}}
+}
+
+func (c Connection) WaitForJob(id uint, timeout time.Duration) (JobOutcome, error) {
+	wakeup := c.waiters.subscribe(c.url, id)
+	defer c.waiters.unsubscribe(id, wakeup)
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(waitPollingInterval)
+	defer ticker.Stop()
+
+	for {
+		outcome, err := c.fetchJobOutcome(id)
+		if err != nil {
+			return JobOutcome{}, err
+		}
+
+		if outcome.State.terminal() {
+			return outcome, nil
+		}
+
+		select {
+		case <-wakeup:
+		case <-ticker.C:
+		case <-deadline.C:
+			return JobOutcome{}, ErrWaitTimeout
+		}
+	}
+}
+
+// subscribe registers a wakeup channel for the given job, starting the shared
+// notification listener if necessary. Until the listener is up (or if it can't
+// be started at all, or the database has no notifications), waiters still make
+// progress by polling.
+func (jw *jobWaiters) subscribe(url string, id uint) chan struct{} {
+	jw.Lock()
+	defer jw.Unlock()
+
+	if jw.listener == nil && url != "" && !jw.closed {
+		jw.listener = pq.NewListener(url, listenerMinReconnectInterval, listenerMaxReconnectInterval, nil)
+		go jw.listen(jw.listener)
+	}
+
+	wakeup := make(chan struct{}, 1)
+	if jw.waiting[id] == nil {
+		jw.waiting[id] = map[chan struct{}]bool{}
+	}
+	jw.waiting[id][wakeup] = true
+
+	return wakeup
+}
+
+func (jw *jobWaiters) unsubscribe(id uint, wakeup chan struct{}) {
+	jw.Lock()
+	defer jw.Unlock()
+
+	delete(jw.waiting[id], wakeup)
+	if len(jw.waiting[id]) == 0 {
+		delete(jw.waiting, id)
+	}
+}
+
+// wake wakes up everybody waiting for the given job, for stores which know
+// when jobs complete without being notified by the database.
+func (jw *jobWaiters) wake(id uint) {
+	jw.Lock()
+	defer jw.Unlock()
+	wakeAll(jw.waiting[id])
+}
+
+// close stops the notification listener, if any; waiters fall back to polling.
+func (jw *jobWaiters) close() {
+	jw.Lock()
+	defer jw.Unlock()
+
+	jw.closed = true
+	if jw.listener != nil {
+		jw.listener.Close()
+		jw.listener = nil
+	}
+}
+
+func (jw *jobWaiters) listen(listener *pq.Listener) {
+	if err := listener.Listen(jobDoneChannel); err != nil {
+		listener.Close()
+
+		jw.Lock()
+		jw.listener = nil
+		jw.Unlock()
+
+		return
+	}
+
+	for notification := range listener.Notify {
+		jw.Lock()
+
+		// A nil notification means the connection was re-established and
+		// notifications may have been missed, so everybody rechecks
+		if notification == nil {
+			for _, wakeups := range jw.waiting {
+				wakeAll(wakeups)
+			}
+		} else if id, err := strconv.ParseUint(notification.Extra, 10, 64); err == nil {
+			wakeAll(jw.waiting[uint(id)])
+		}
+
+		jw.Unlock()
+	}
+}
+
+func wakeAll(wakeups map[chan struct{}]bool) {
+	for wakeup := range wakeups {
+		select {
+		case wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
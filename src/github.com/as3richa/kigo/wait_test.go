@@ -0,0 +1,48 @@
+package kigo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForJob(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		finished, err := c.pushJobTo("default", "waitFinished", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		failed, err := c.pushJobTo("default", "waitFailed", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		markRunning(t, c, finished, failed)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			c.finishJob(finished, nil)
+			c.failJob(failed, errors.New("augh"))
+		}()
+
+		outcome, err := c.WaitForJob(finished, 5*time.Second)
+		expectSuccess(t, err)
+		if outcome.State != JobFinished || outcome.Error != nil {
+			t.Errorf("expected finished job with no error, got %v", outcome)
+		}
+
+		outcome, err = c.WaitForJob(failed, 5*time.Second)
+		expectSuccess(t, err)
+		if outcome.State != JobFailed || outcome.Error == nil || outcome.Error.Error() != "augh" {
+			t.Errorf("expected failed job with error, got %v", outcome)
+		}
+	})
+}
+
+func TestWaitForJobTimesOut(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		id, err := c.pushJobTo("default", "waitForever", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		if _, err := c.WaitForJob(id, 100*time.Millisecond); err != ErrWaitTimeout {
+			t.Errorf("expected %v, got %v", ErrWaitTimeout, err)
+		}
+	})
+}
@@ -2,33 +2,58 @@ package kigo
 
 import (
 	"encoding/json"
-  "net/http"
-  "time"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/braintree/manners"
+	"github.com/jinzhu/gorm"
 	"github.com/julienschmidt/httprouter"
 )
 
 const DefaultWebfaceAddress = "0.0.0.0:32601"
 const apiPrefix = "/api"
 
+var errNoQueueName = errors.New("missing destination queue parameter to")
+var errEmptyBulkFilter = errors.New("refusing to act on every job; pass all=true to do so")
+
+// WebfaceOptions configures RunWebfaceWithOptions. Without an Authenticator,
+// every request is treated as coming from a read-only user, unless
+// UnauthenticatedAdmin is set; that grants everyone who can reach the webface
+// the admin role, and is only safe behind some other form of access control.
+// ReadOnly refuses the mutating routes to everyone, admins included.
+type WebfaceOptions struct {
+	Addr string
+
+	Authenticator        Authenticator
+	UnauthenticatedAdmin bool
+	ReadOnly             bool
+
+	Terminator chan struct{}
+}
+
 func (c Connection) RunWebface(addr string, terminator chan struct{}) error {
+	return c.RunWebfaceWithOptions(&WebfaceOptions{Addr: addr, Terminator: terminator})
+}
+
+func (c Connection) RunWebfaceWithOptions(options *WebfaceOptions) error {
+	addr := options.Addr
 	if addr == "" {
 		addr = DefaultWebfaceAddress
 	}
 
-	router := httprouter.New()
-	c.defineApiRoutes(router)
-
 	server := manners.NewWithServer(&http.Server{
 		Addr:           addr,
-		Handler:        router,
+		Handler:        c.webfaceHandler(options),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	})
 
-	if terminator != nil {
+	if terminator := options.Terminator; terminator != nil {
 		go func() {
 			<-terminator
 			server.Close()
@@ -38,15 +63,468 @@ func (c Connection) RunWebface(addr string, terminator chan struct{}) error {
 	return server.ListenAndServe()
 }
 
+func (c Connection) webfaceHandler(options *WebfaceOptions) http.Handler {
+	router := httprouter.New()
+	c.defineApiRoutes(router)
+	c.defineDashboardRoutes(router)
+
+	return webfaceGuard{
+		handler:        router,
+		authenticate:   options.Authenticator,
+		anonymousAdmin: options.UnauthenticatedAdmin,
+		readOnly:       options.ReadOnly,
+	}
+}
+
+type durationStatsView struct {
+	TaskName    string  `json:"taskName"`
+	Count       uint    `json:"count"`
+	MeanSeconds float64 `json:"meanSeconds"`
+	P50Seconds  float64 `json:"p50Seconds"`
+	P95Seconds  float64 `json:"p95Seconds"`
+	P99Seconds  float64 `json:"p99Seconds"`
+	MaxSeconds  float64 `json:"maxSeconds"`
+}
+
+type durationWindowView struct {
+	Window        string              `json:"window"`
+	WindowSeconds float64             `json:"windowSeconds"`
+	Tasks         []durationStatsView `json:"tasks"`
+}
+
+type queueView struct {
+	Name           string            `json:"name"`
+	Counts         map[JobState]uint `json:"counts"`
+	Scheduled      uint              `json:"scheduled"`
+	LatencySeconds float64           `json:"latencySeconds"`
+	LiveWorkers    uint              `json:"liveWorkers"`
+}
+
 func (c Connection) defineApiRoutes(router *httprouter.Router) {
 	ping := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		if err := c.db.DB().Ping(); err != nil {
-			response, _ := json.Marshal(map[string]string{"error": err.Error()})
-			http.Error(w, string(response), http.StatusServiceUnavailable)
+		if err := c.store.Ping(); err != nil {
+			writeJSONError(w, err, http.StatusServiceUnavailable)
 		} else {
 			w.Write([]byte("{}\n"))
 		}
 	}
 
+	jobProgress := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id, ok := jobIDParam(w, params)
+		if !ok {
+			return
+		}
+
+		progress, err := c.JobProgress(id)
+		if err != nil {
+			writeJobError(w, id, err)
+		} else if progress == nil {
+			w.Write([]byte("{}\n"))
+		} else {
+			writeJSON(w, progress)
+		}
+	}
+
+	jobLogs := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id, ok := jobIDParam(w, params)
+		if !ok {
+			return
+		}
+
+		lines, err := c.JobLogs(id)
+		if err != nil {
+			writeJobError(w, id, err)
+		} else {
+			writeJSON(w, map[string]interface{}{"lines": lines})
+		}
+	}
+
+	queues := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		stats, err := c.QueueStats()
+		if err != nil {
+			writeJSONError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		views := make([]queueView, len(stats))
+		for i, queueStats := range stats {
+			views[i] = queueView{
+				Name:           queueStats.Name,
+				Counts:         queueStats.Counts,
+				Scheduled:      queueStats.Scheduled,
+				LatencySeconds: queueStats.Latency.Seconds(),
+				LiveWorkers:    queueStats.LiveWorkers,
+			}
+		}
+
+		writeJSON(w, map[string]interface{}{"queues": views})
+	}
+
+	listJobs := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		filter, err := parseJobFilter(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		cursor, err := parseUintParam(r.URL.Query(), "after")
+		if err != nil {
+			writeJSONError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		limit, err := parseUintParam(r.URL.Query(), "limit")
+		if err != nil {
+			writeJSONError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		page, err := c.ListJobs(filter, cursor, limit)
+		if err != nil {
+			writeJSONError(w, err, http.StatusInternalServerError)
+		} else {
+			writeJSON(w, page)
+		}
+	}
+
+	showJob := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id, ok := jobIDParam(w, params)
+		if !ok {
+			return
+		}
+
+		info, err := c.FindJob(id)
+		if err != nil {
+			writeJobError(w, id, err)
+		} else {
+			writeJSON(w, info)
+		}
+	}
+
+	listWorkers := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		workers, err := c.Workers()
+		if err != nil {
+			writeJSONError(w, err, http.StatusInternalServerError)
+		} else {
+			writeJSON(w, map[string]interface{}{"workers": workers})
+		}
+	}
+
+	showWorker := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id, err := strconv.ParseUint(params.ByName("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, fmt.Errorf("bad worker id %q", params.ByName("id")), http.StatusBadRequest)
+			return
+		}
+
+		worker, err := c.FindWorker(uint(id))
+		if err == gorm.ErrRecordNotFound {
+			writeJSONError(w, fmt.Errorf("no such worker %d", id), http.StatusNotFound)
+		} else if err != nil {
+			writeJSONError(w, err, http.StatusInternalServerError)
+		} else {
+			writeJSON(w, worker)
+		}
+	}
+
+	jobStats := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query, err := parseJobStatsQuery(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		series, err := c.JobStats(query)
+		if err != nil {
+			writeJSONError(w, err, http.StatusInternalServerError)
+		} else {
+			writeJSON(w, map[string]interface{}{"series": series})
+		}
+	}
+
+	durations := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		windows := DefaultDurationWindows
+		if values := r.URL.Query()["window"]; len(values) != 0 {
+			windows = nil
+			for _, value := range values {
+				window, err := time.ParseDuration(value)
+				if err != nil || window <= 0 {
+					writeJSONError(w, fmt.Errorf("bad window %q; expected a duration such as 1h", value), http.StatusBadRequest)
+					return
+				}
+				windows = append(windows, window)
+			}
+		}
+
+		taskName := r.URL.Query().Get("task")
+		views := make([]durationWindowView, 0, len(windows))
+
+		for _, window := range windows {
+			stats, err := c.DurationStats(window)
+			if err != nil {
+				writeJSONError(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			view := durationWindowView{Window: window.String(), WindowSeconds: window.Seconds(), Tasks: []durationStatsView{}}
+			for _, taskStats := range stats {
+				if taskName != "" && taskStats.TaskName != taskName {
+					continue
+				}
+
+				view.Tasks = append(view.Tasks, durationStatsView{
+					TaskName:    taskStats.TaskName,
+					Count:       taskStats.Count,
+					MeanSeconds: taskStats.Mean.Seconds(),
+					P50Seconds:  taskStats.P50.Seconds(),
+					P95Seconds:  taskStats.P95.Seconds(),
+					P99Seconds:  taskStats.P99.Seconds(),
+					MaxSeconds:  taskStats.Max.Seconds(),
+				})
+			}
+
+			views = append(views, view)
+		}
+
+		writeJSON(w, map[string]interface{}{"windows": views})
+	}
+
+	jobAction := func(action func(id uint, r *http.Request) error) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			id, ok := jobIDParam(w, params)
+			if !ok {
+				return
+			}
+
+			if err := action(id, r); err != nil {
+				writeJobError(w, id, err)
+				return
+			}
+
+			if info, err := c.FindJob(id); err == gorm.ErrRecordNotFound {
+				w.Write([]byte("{}\n"))
+			} else if err != nil {
+				writeJobError(w, id, err)
+			} else {
+				writeJSON(w, info)
+			}
+		}
+	}
+
+	bulkAction := func(action func(filter JobFilter, r *http.Request) (uint, error)) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			filter, err := parseBulkFilter(r)
+			if err != nil {
+				writeJSONError(w, err, http.StatusBadRequest)
+				return
+			}
+
+			affected, err := action(filter, r)
+			if err == errNoQueueName {
+				writeJSONError(w, err, http.StatusBadRequest)
+			} else if err != nil {
+				writeJSONError(w, err, http.StatusInternalServerError)
+			} else {
+				writeJSON(w, map[string]uint{"affected": affected})
+			}
+		}
+	}
+
 	router.GET(apiPrefix+"/ping", ping)
+	router.GET(apiPrefix+"/queues", queues)
+	router.GET(apiPrefix+"/stats", jobStats)
+	router.GET(apiPrefix+"/durations", durations)
+	router.GET(apiPrefix+"/workers", listWorkers)
+	router.GET(apiPrefix+"/workers/:id", showWorker)
+	router.GET(apiPrefix+"/jobs", listJobs)
+	router.GET(apiPrefix+"/jobs/:id", showJob)
+	router.GET(apiPrefix+"/jobs/:id/progress", jobProgress)
+	router.GET(apiPrefix+"/jobs/:id/logs", jobLogs)
+
+	router.POST(apiPrefix+"/jobs/:id/retry", jobAction(func(id uint, _ *http.Request) error {
+		return c.RetryJob(id)
+	}))
+	router.POST(apiPrefix+"/jobs/:id/run", jobAction(func(id uint, _ *http.Request) error {
+		return c.RunJobNow(id)
+	}))
+	router.POST(apiPrefix+"/jobs/:id/delete", jobAction(func(id uint, _ *http.Request) error {
+		return c.DeleteJob(id)
+	}))
+	router.POST(apiPrefix+"/jobs/:id/requeue", jobAction(func(id uint, r *http.Request) error {
+		queueName, err := queueNameParam(r)
+		if err != nil {
+			return err
+		}
+		return c.RequeueJob(id, queueName)
+	}))
+
+	router.POST(apiPrefix+"/bulk/retry", bulkAction(func(filter JobFilter, _ *http.Request) (uint, error) {
+		return c.RetryJobs(filter)
+	}))
+	router.POST(apiPrefix+"/bulk/run", bulkAction(func(filter JobFilter, _ *http.Request) (uint, error) {
+		return c.RunJobsNow(filter)
+	}))
+	router.POST(apiPrefix+"/bulk/delete", bulkAction(func(filter JobFilter, _ *http.Request) (uint, error) {
+		return c.DeleteJobs(filter)
+	}))
+	router.POST(apiPrefix+"/bulk/requeue", bulkAction(func(filter JobFilter, r *http.Request) (uint, error) {
+		queueName, err := queueNameParam(r)
+		if err != nil {
+			return 0, err
+		}
+		return c.RequeueJobs(filter, queueName)
+	}))
+}
+
+func parseJobFilter(query url.Values) (JobFilter, error) {
+	filter := JobFilter{
+		QueueName: query.Get("queue"),
+		TaskName:  query.Get("task"),
+	}
+
+	if name := query.Get("state"); name != "" {
+		state, err := ParseJobState(name)
+		if err != nil {
+			return JobFilter{}, err
+		}
+		filter.State = &state
+	}
+
+	times := map[string]*time.Time{
+		"enqueuedAfter":  &filter.EnqueuedAfter,
+		"enqueuedBefore": &filter.EnqueuedBefore,
+		"startAtAfter":   &filter.StartAtAfter,
+		"startAtBefore":  &filter.StartAtBefore,
+		"startedAfter":   &filter.StartedAfter,
+		"startedBefore":  &filter.StartedBefore,
+		"finishedAfter":  &filter.FinishedAfter,
+		"finishedBefore": &filter.FinishedBefore,
+	}
+
+	for name, value := range times {
+		if query.Get(name) == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			return JobFilter{}, fmt.Errorf("bad %s %q; expected an RFC 3339 timestamp", name, query.Get(name))
+		}
+		*value = t
+	}
+
+	return filter, nil
+}
+
+func parseJobStatsQuery(values url.Values) (JobStatsQuery, error) {
+	query := JobStatsQuery{
+		QueueName: values.Get("queue"),
+		TaskName:  values.Get("task"),
+	}
+
+	for name, value := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if values.Get(name) == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, values.Get(name))
+		if err != nil {
+			return JobStatsQuery{}, fmt.Errorf("bad %s %q; expected an RFC 3339 timestamp", name, values.Get(name))
+		}
+		*value = t
+	}
+
+	if values.Get("resolution") != "" {
+		resolution, err := time.ParseDuration(values.Get("resolution"))
+		if err != nil || resolution < time.Minute {
+			return JobStatsQuery{}, fmt.Errorf("bad resolution %q; expected a duration of at least 1m", values.Get("resolution"))
+		}
+		query.Resolution = resolution
+	}
+
+	return query, nil
+}
+
+// parseBulkFilter reads a bulk action's filter from the request's query string
+// and form body. An empty filter matches every job, so it must be asked for
+// explicitly with all=true.
+func parseBulkFilter(r *http.Request) (JobFilter, error) {
+	if err := r.ParseForm(); err != nil {
+		return JobFilter{}, err
+	}
+
+	filter, err := parseJobFilter(r.Form)
+	if err != nil {
+		return JobFilter{}, err
+	}
+
+	if filter == (JobFilter{}) && r.Form.Get("all") != "true" {
+		return JobFilter{}, errEmptyBulkFilter
+	}
+
+	return filter, nil
+}
+
+// queueNameParam reads the destination queue for a requeue, which can't be
+// called "queue" since that already filters by the jobs' current queue.
+func queueNameParam(r *http.Request) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", err
+	}
+
+	if r.Form.Get("to") == "" {
+		return "", errNoQueueName
+	}
+
+	return r.Form.Get("to"), nil
+}
+
+func parseUintParam(query url.Values, name string) (uint, error) {
+	if query.Get(name) == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseUint(query.Get(name), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", name, query.Get(name))
+	}
+
+	return uint(value), nil
+}
+
+func jobIDParam(w http.ResponseWriter, params httprouter.Params) (uint, bool) {
+	id, err := strconv.ParseUint(params.ByName("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, fmt.Errorf("bad job id %q", params.ByName("id")), http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func writeJobError(w http.ResponseWriter, id uint, err error) {
+	switch err {
+	case gorm.ErrRecordNotFound:
+		writeJSONError(w, fmt.Errorf("no such job %d", id), http.StatusNotFound)
+	case ErrJobNotFailed, ErrJobNotEnqueued, ErrJobRunning:
+		writeJSONError(w, err, http.StatusConflict)
+	case errNoQueueName:
+		writeJSONError(w, err, http.StatusBadRequest)
+	default:
+		writeJSONError(w, err, http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		writeJSONError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(response, '\n'))
+}
+
+func writeJSONError(w http.ResponseWriter, err error, status int) {
+	response, _ := json.Marshal(map[string]string{"error": err.Error()})
+	http.Error(w, string(response), status)
 }
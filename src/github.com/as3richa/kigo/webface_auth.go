@@ -0,0 +1,235 @@
+package kigo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const csrfCookieName = "kigo_csrf"
+const csrfFieldName = "csrf"
+
+var errUnauthenticated = errors.New("authentication required")
+var errReadOnly = errors.New("read-only access; this action requires the admin role")
+var errBadCSRFToken = errors.New("missing or invalid CSRF token; reload the page and try again")
+var errCrossOrigin = errors.New("cross-origin request refused")
+
+type WebfaceRole uint
+
+const (
+	WebfaceReadOnly WebfaceRole = iota + 1
+	WebfaceAdmin
+)
+
+// Authenticator identifies the role of a webface request's caller. It returns
+// false if the request doesn't carry credentials it recognises, in which case
+// the request is refused.
+type Authenticator func(r *http.Request) (WebfaceRole, bool)
+
+type BasicAuthUser struct {
+	Password string
+	Role     WebfaceRole
+}
+
+// BasicAuthenticator accepts HTTP basic auth credentials for the given users,
+// keyed by username.
+func BasicAuthenticator(users map[string]BasicAuthUser) Authenticator {
+	return func(r *http.Request) (WebfaceRole, bool) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return 0, false
+		}
+
+		user, ok := users[username]
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) != 1 {
+			return 0, false
+		}
+
+		return user.Role, true
+	}
+}
+
+// BearerTokenAuthenticator accepts any of the given static tokens in an
+// "Authorization: Bearer" header.
+func BearerTokenAuthenticator(tokens map[string]WebfaceRole) Authenticator {
+	return func(r *http.Request) (WebfaceRole, bool) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			return 0, false
+		}
+
+		presented := []byte(strings.TrimPrefix(header, "Bearer "))
+		for token, role := range tokens {
+			if subtle.ConstantTimeCompare(presented, []byte(token)) == 1 {
+				return role, true
+			}
+		}
+
+		return 0, false
+	}
+}
+
+// FuncAuthenticator grants role to every request for which check returns true.
+func FuncAuthenticator(check func(*http.Request) bool, role WebfaceRole) Authenticator {
+	return func(r *http.Request) (WebfaceRole, bool) {
+		return role, check(r)
+	}
+}
+
+// AnyAuthenticator tries each authenticator in turn, and uses the first which
+// recognises the request.
+func AnyAuthenticator(authenticators ...Authenticator) Authenticator {
+	return func(r *http.Request) (WebfaceRole, bool) {
+		for _, authenticate := range authenticators {
+			if role, ok := authenticate(r); ok {
+				return role, true
+			}
+		}
+		return 0, false
+	}
+}
+
+type webfaceSession struct {
+	role      WebfaceRole
+	csrfToken string
+}
+
+type webfaceSessionKey struct{}
+
+func sessionFor(r *http.Request) webfaceSession {
+	if session, ok := r.Context().Value(webfaceSessionKey{}).(webfaceSession); ok {
+		return session
+	}
+	return webfaceSession{role: WebfaceReadOnly}
+}
+
+// webfaceGuard authenticates every request before passing it on. Only admins
+// may use methods other than GET and HEAD; such requests must also carry the
+// CSRF token issued to the browser if they come from the HTML dashboard, or
+// come from the same origin if they're addressed to the JSON API. Without an
+// authenticator, callers are read-only unless anonymousAdmin is set.
+type webfaceGuard struct {
+	handler        http.Handler
+	authenticate   Authenticator
+	anonymousAdmin bool
+	readOnly       bool
+}
+
+func (g webfaceGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api := strings.HasPrefix(r.URL.Path, apiPrefix+"/")
+
+	refuse := func(err error, status int) {
+		if api {
+			writeJSONError(w, err, status)
+		} else {
+			renderDashboardStatus(w, r, status, err)
+		}
+	}
+
+	session := webfaceSession{role: WebfaceReadOnly}
+	if g.anonymousAdmin {
+		session.role = WebfaceAdmin
+	}
+
+	if g.authenticate != nil {
+		role, ok := g.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kigo"`)
+			refuse(errUnauthenticated, http.StatusUnauthorized)
+			return
+		}
+		session.role = role
+	}
+
+	if g.readOnly {
+		session.role = WebfaceReadOnly
+	}
+
+	if !api {
+		session.csrfToken = csrfCookie(w, r)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), webfaceSessionKey{}, session))
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		if session.role != WebfaceAdmin {
+			refuse(errReadOnly, http.StatusForbidden)
+			return
+		}
+
+		if api && crossOrigin(r) {
+			refuse(errCrossOrigin, http.StatusForbidden)
+			return
+		}
+
+		if !api && !validCSRFToken(r, session.csrfToken) {
+			refuse(errBadCSRFToken, http.StatusForbidden)
+			return
+		}
+	}
+
+	g.handler.ServeHTTP(w, r)
+}
+
+// csrfCookie returns the browser's CSRF token, issuing a new one if it doesn't
+// have one yet.
+func csrfCookie(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(bytes)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token
+}
+
+func validCSRFToken(r *http.Request, expected string) bool {
+	if err := r.ParseForm(); err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.PostForm.Get(csrfFieldName)), []byte(expected)) == 1
+}
+
+// crossOrigin reports whether a browser sent the request on behalf of a page
+// on another origin. Non-browser clients don't send Origin, and are allowed.
+func crossOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	parsed, err := url.Parse(origin)
+	return err != nil || parsed.Host != r.Host
+}
+
+func sessionTemplateFuncs(r *http.Request) template.FuncMap {
+	session := sessionFor(r)
+
+	return template.FuncMap{
+		"canWrite": func() bool {
+			return session.role == WebfaceAdmin
+		},
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(session.csrfToken) + `">`)
+		},
+	}
+}
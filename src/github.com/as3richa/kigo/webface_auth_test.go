@@ -0,0 +1,121 @@
+package kigo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebfaceGuard(t *testing.T) {
+	guard := webfaceGuard{
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+		authenticate: AnyAuthenticator(
+			BasicAuthenticator(map[string]BasicAuthUser{
+				"admin":   {Password: "hunter2", Role: WebfaceAdmin},
+				"support": {Password: "letmein", Role: WebfaceReadOnly},
+			}),
+			BearerTokenAuthenticator(map[string]WebfaceRole{"s3cret": WebfaceAdmin}),
+		),
+	}
+
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		guard.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	request := httptest.NewRequest("GET", "/jobs", nil)
+	if code := serve(request).Code; code != http.StatusUnauthorized {
+		t.Errorf("expected anonymous request to be refused, got %d", code)
+	}
+
+	request = httptest.NewRequest("GET", "/jobs", nil)
+	request.SetBasicAuth("admin", "wrong")
+	if code := serve(request).Code; code != http.StatusUnauthorized {
+		t.Errorf("expected bad password to be refused, got %d", code)
+	}
+
+	request = httptest.NewRequest("GET", "/jobs", nil)
+	request.SetBasicAuth("support", "letmein")
+	if code := serve(request).Code; code != http.StatusOK {
+		t.Errorf("expected read-only user to be able to read, got %d", code)
+	}
+
+	request = httptest.NewRequest("POST", apiPrefix+"/jobs/1/retry", nil)
+	request.SetBasicAuth("support", "letmein")
+	if code := serve(request).Code; code != http.StatusForbidden {
+		t.Errorf("expected read-only user to be refused a mutation, got %d", code)
+	}
+
+	request = httptest.NewRequest("POST", apiPrefix+"/jobs/1/retry", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+	if code := serve(request).Code; code != http.StatusOK {
+		t.Errorf("expected bearer token to be able to mutate, got %d", code)
+	}
+
+	request = httptest.NewRequest("POST", apiPrefix+"/jobs/1/retry", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+	request.Header.Set("Origin", "http://evil.example.com")
+	if code := serve(request).Code; code != http.StatusForbidden {
+		t.Errorf("expected cross-origin mutation to be refused, got %d", code)
+	}
+
+	request = httptest.NewRequest("GET", "/jobs", nil)
+	request.SetBasicAuth("admin", "hunter2")
+	cookies := serve(request).Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("expected a CSRF cookie to be issued, got %v", cookies)
+	}
+
+	form := func(token string) *http.Request {
+		request := httptest.NewRequest("POST", "/jobs/1/retry", strings.NewReader(url.Values{csrfFieldName: {token}}.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth("admin", "hunter2")
+		request.AddCookie(cookies[0])
+		return request
+	}
+
+	if code := serve(form("forged")).Code; code != http.StatusForbidden {
+		t.Errorf("expected form without the CSRF token to be refused, got %d", code)
+	}
+
+	if code := serve(form(cookies[0].Value)).Code; code != http.StatusOK {
+		t.Errorf("expected form with the CSRF token to be accepted, got %d", code)
+	}
+
+	guard.readOnly = true
+	if code := serve(form(cookies[0].Value)).Code; code != http.StatusForbidden {
+		t.Errorf("expected read-only mode to refuse admins' mutations, got %d", code)
+	}
+}
+
+func TestWebfaceGuardWithoutAuthenticator(t *testing.T) {
+	guard := webfaceGuard{
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+	}
+
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		guard.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if code := serve(httptest.NewRequest("GET", apiPrefix+"/jobs", nil)).Code; code != http.StatusOK {
+		t.Errorf("expected anonymous reads to be allowed, got %d", code)
+	}
+
+	if code := serve(httptest.NewRequest("POST", apiPrefix+"/bulk/delete?all=true", nil)).Code; code != http.StatusForbidden {
+		t.Errorf("expected anonymous mutations to be refused by default, got %d", code)
+	}
+
+	guard.anonymousAdmin = true
+	if code := serve(httptest.NewRequest("POST", apiPrefix+"/bulk/delete?all=true", nil)).Code; code != http.StatusOK {
+		t.Errorf("expected anonymous mutations to be allowed once opted in, got %d", code)
+	}
+}
@@ -0,0 +1,72 @@
+package kigo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestParseJobFilter(t *testing.T) {
+	query := url.Values{
+		"queue":         {"alpha"},
+		"task":          {"SyncInvoice"},
+		"state":         {"failed"},
+		"finishedAfter": {"2017-06-01T10:00:00Z"},
+	}
+
+	filter, err := parseJobFilter(query)
+	expectSuccess(t, err)
+
+	if filter.QueueName != "alpha" || filter.TaskName != "SyncInvoice" || filter.State == nil || *filter.State != JobFailed {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+
+	if !filter.FinishedAfter.Equal(time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)) || !filter.StartedAfter.IsZero() {
+		t.Errorf("unexpected time ranges in filter %+v", filter)
+	}
+
+	if _, err := parseJobFilter(url.Values{"state": {"exploded"}}); err == nil {
+		t.Error("expected bad state to be rejected")
+	}
+
+	if _, err := parseJobFilter(url.Values{"enqueuedBefore": {"yesterday"}}); err == nil {
+		t.Error("expected bad timestamp to be rejected")
+	}
+}
+
+func TestPingWithoutSQL(t *testing.T) {
+	c, err := ConnectMemory(MemoryOptions{})
+	expectSuccess(t, err)
+	defer c.Close()
+
+	router := httprouter.New()
+	c.defineApiRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", apiPrefix+"/ping", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected a memory connection to be healthy, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestBulkActionsRequireAFilter(t *testing.T) {
+	router := httprouter.New()
+	Connection{}.defineApiRoutes(router)
+	Connection{}.defineDashboardRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", apiPrefix+"/bulk/delete", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected an unfiltered bulk delete to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", apiPrefix+"/bulk/requeue?queue=alpha&state=bogus", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad filter to be rejected, got %d", recorder.Code)
+	}
+}
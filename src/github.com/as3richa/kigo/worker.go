@@ -20,9 +20,17 @@ const heartbeatInterval = 10 * time.Second
 var signals = []os.Signal{os.Interrupt, os.Kill, syscall.SIGTERM}
 var signalError = errors.New("received termination signal")
 var terminatorError = errors.New("external terminator was triggered")
+var apiShutdownError = errors.New("shutdown was requested through the api")
+var errJobNotRunning = errors.New("job is not running on this worker")
+
+// ErrInsecureApiAddr is returned by RunWorkerWithOptions if the worker's API
+// would listen on a non-loopback address without an ApiAuthenticator.
+var ErrInsecureApiAddr = errors.New("refusing to serve the worker api on a non-loopback address without an ApiAuthenticator")
+var errJobNotCancellable = errors.New("job's task doesn't take a terminator")
 
 type Job struct {
 	ID         uint
+	QueueName  string
 	TaskName   string
 	Parameters []interface{}
 }
@@ -30,15 +38,30 @@ type Job struct {
 type WorkerOptions struct {
 	CustomName string
 
+	// ApiAddr is the address of the worker's API server, which serves metrics
+	// and status as well as control routes (quiet, resume, shutdown and job
+	// cancellation). The default binds to localhost only; the worker refuses
+	// to serve the API on any other address without an ApiAuthenticator.
 	ApiAddr string
 
+	// ApiAuthenticator, if set, guards the API's control routes, which then
+	// require the admin role; metrics and status stay open.
+	ApiAuthenticator Authenticator
+
 	Logger       logrus.FieldLogger
 	CatchSignals bool
 
+	EnableProfiling bool
+
 	TermGracePeriod time.Duration
 
 	Terminator chan struct{}
 
+	// EventBus receives the worker's events; it defaults to the Connection's.
+	EventBus *EventBus
+
+	Retention *RetentionPolicy
+
 	BootHook  func(string, []string, uint, *WorkerOptions)
 	ErrorHook func(error)
 	TermHook  func(error)
@@ -58,14 +81,18 @@ type threadInfo struct {
 }
 
 type threadResult struct {
-	id  uint
-	err error
+	id      uint
+	outcome jobOutcome
 }
 
 type worker struct {
-	log logrus.FieldLogger
+	log     logrus.FieldLogger
+	events  *EventBus
+	metrics *workerMetrics
+	stats   *jobStatsRecorder
 
-	id uint
+	id   uint
+	name string
 
 	globalTerminator     chan error
 	subroutineTerminator chan struct{}
@@ -77,13 +104,14 @@ type worker struct {
 
 		concurrency uint
 		counter     uint
+		quiet       bool
 
 		activeThreads map[uint]threadInfo
 	}
 }
 
 var DefaultWorkerOptions = &WorkerOptions{
-	ApiAddr:         "0.0.0.0:32600",
+	ApiAddr:         "127.0.0.1:32600",
 	CatchSignals:    true,
 	TermGracePeriod: 10 * time.Second,
 }
@@ -109,9 +137,18 @@ func (c Connection) RunWorkerWithOptions(queueNames []string, concurrency uint,
 		log = DefaultWorkerLogger
 	}
 
+	events := options.EventBus
+	if events == nil {
+		events = c.eventBus()
+	}
+
 	worker := &worker{
 		log:                  log.WithFields(logrus.Fields{"workerName": workerName}),
-		globalTerminator:     make(chan error, 3),
+		events:               events,
+		metrics:              newWorkerMetrics(),
+		stats:                newJobStatsRecorder(),
+		name:                 workerName,
+		globalTerminator:     make(chan error, 4),
 		subroutineTerminator: make(chan struct{}),
 	}
 	worker.sharedState.queueNames = queueNames
@@ -120,6 +157,14 @@ func (c Connection) RunWorkerWithOptions(queueNames []string, concurrency uint,
 
 	var err error
 
+	if options.ApiAddr != "" && options.ApiAuthenticator == nil && !loopbackAddr(options.ApiAddr) {
+		err = ErrInsecureApiAddr
+		if options.ErrorHook != nil {
+			options.ErrorHook(err)
+		}
+		return err
+	}
+
 	if worker.id, err = c.createWorker(workerName, queueNames, concurrency); err != nil {
 		if options.ErrorHook != nil {
 			options.ErrorHook(err)
@@ -127,7 +172,17 @@ func (c Connection) RunWorkerWithOptions(queueNames []string, concurrency uint,
 		return err
 	}
 
+	unsubscribeHooks := events.Subscribe(worker.hookListener(options))
+	defer unsubscribeHooks()
+
+	unsubscribeMetrics := events.Subscribe(worker.metrics.listener(worker.id))
+	defer unsubscribeMetrics()
+
+	unsubscribeStats := events.Subscribe(worker.stats.listener(worker.id))
+	defer unsubscribeStats()
+
 	log.Info("working booting up")
+	worker.emit(Event{Kind: WorkerBootedEvent})
 	if options.BootHook != nil {
 		options.BootHook(workerName, queueNames, concurrency, options)
 	}
@@ -141,17 +196,25 @@ func (c Connection) RunWorkerWithOptions(queueNames []string, concurrency uint,
 	}
 
 	if options.ApiAddr != "" {
-		go worker.apiHttpServer(options.ApiAddr)
+		go worker.apiHttpServer(options.ApiAddr, options.EnableProfiling, options.ApiAuthenticator)
 	}
 
 	go worker.heartbeat(c)
 
+	if options.Retention != nil {
+		if err := c.requireSQL(); err != nil {
+			log.WithFields(logrus.Fields{"error": err}).Warn("ignoring retention policy")
+		} else {
+			go worker.pruner(c, *options.Retention)
+		}
+	}
+
 	go worker.scheduler(c)
 
 	err = <-worker.globalTerminator
 	close(worker.subroutineTerminator)
 
-	if err == signalError || err == terminatorError {
+	if err == signalError || err == terminatorError || err == apiShutdownError {
 		log.WithFields(logrus.Fields{"reason": err}).Info("worker terminating")
 		err = nil
 	} else {
@@ -162,7 +225,9 @@ func (c Connection) RunWorkerWithOptions(queueNames []string, concurrency uint,
 	}
 
 	_ = c.terminateWorker(worker.id)
+	_ = worker.stats.flush(c, time.Now())
 
+	worker.emit(Event{Kind: WorkerStoppedEvent, Error: err})
 	if options.TermHook != nil {
 		options.TermHook(err)
 	}
@@ -171,6 +236,37 @@ func (c Connection) RunWorkerWithOptions(queueNames []string, concurrency uint,
 	return err
 }
 
+func (w *worker) emit(event Event) {
+	event.WorkerID = w.id
+	event.WorkerName = w.name
+	w.events.emit(event)
+}
+
+// hookListener adapts the job hooks in WorkerOptions to a listener on the
+// worker's event bus, which only reacts to this worker's events.
+func (w *worker) hookListener(options *WorkerOptions) Listener {
+	return func(event Event) {
+		if event.WorkerID != w.id || event.Job == nil {
+			return
+		}
+
+		switch event.Kind {
+		case JobStartedEvent:
+			if options.JobRunningHook != nil {
+				options.JobRunningHook(event.Job)
+			}
+		case JobFailedEvent:
+			if options.JobFailedHook != nil {
+				options.JobFailedHook(event.Job, event.Error)
+			}
+		case JobFinishedEvent:
+			if options.JobDoneHook != nil {
+				options.JobDoneHook(event.Job)
+			}
+		}
+	}
+}
+
 func (w *worker) signalCatcher() {
 	c := make(chan os.Signal, 1)
 
@@ -201,100 +297,184 @@ func (w *worker) scheduler(c Connection) {
 	ticker := time.NewTicker(pollingInterval)
 	defer ticker.Stop()
 
-	results := make(chan threadResult)
-
 	w.sharedState.Lock()
-	w.log.WithFields(logrus.Fields{"queues": w.sharedState.queueNames}).Info("starting scheduler")
+	queueNames := w.sharedState.queueNames
+	results := make(chan threadResult, w.sharedState.concurrency)
 	w.sharedState.Unlock()
 
-	for {
-		w.sharedState.Lock()
+	w.log.WithFields(logrus.Fields{"queues": queueNames}).Info("starting scheduler")
 
-		if w.sharedState.concurrency > uint(len(w.sharedState.activeThreads)) {
-			job, err := c.popJobFrom(w.id, w.sharedState.queueNames)
-			if err != nil {
-				w.log.WithFields(logrus.Fields{"error": err}).Error("couldn't pop job")
-			} else if job != nil {
-				w.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName}).Info("popped job")
-				if err := w.spawnThread(job, results); err != nil {
-					w.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": err}).Info("couldn't start job")
-					c.failJob(job.ID, fmt.Errorf("couldn't start job %d: %v", job.ID, err))
-				}
-			}
-		}
-
-		for resultsEmpty := false; !resultsEmpty; {
-			select {
-			case result := <-results:
-				threadID := result.id
-				returnValue := result.err
-				job := w.sharedState.activeThreads[threadID].job
-				delete(w.sharedState.activeThreads, threadID)
-
-				fmt.Printf("%v %v %v\n", threadID, returnValue, job)
-
-				if returnValue != nil {
-					w.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName, "error": returnValue}).Error("job failed")
-					c.failJob(job.ID, fmt.Errorf("job %d failed: %v", job.ID, returnValue))
-				} else {
-					w.log.WithFields(logrus.Fields{"id": job.ID, "taskName": job.TaskName}).Info("job finished peacefully")
-					c.finishJob(job.ID)
-				}
-			default:
-				resultsEmpty = true
-			}
+	for {
+		if free := w.freeSlots(); free > 0 {
+			w.fillSlots(c, queueNames, free, results)
 		}
 
-		w.sharedState.Unlock()
-
 		select {
 		case <-w.subroutineTerminator:
 			w.log.Info("terminating scheduler")
 			return
+		case result := <-results:
+			w.reapThread(c, result)
+			for resultsEmpty := false; !resultsEmpty; {
+				select {
+				case result := <-results:
+					w.reapThread(c, result)
+				default:
+					resultsEmpty = true
+				}
+			}
 		case <-ticker.C:
 		}
 	}
 }
 
-func (w *worker) spawnThread(job *Job, results chan<- threadResult) error {
-	now := time.Now()
+func (w *worker) freeSlots() uint {
+	w.sharedState.Lock()
+	defer w.sharedState.Unlock()
 
+	active := uint(len(w.sharedState.activeThreads))
+	if w.sharedState.quiet || active >= w.sharedState.concurrency {
+		return 0
+	}
+	return w.sharedState.concurrency - active
+}
+
+func (w *worker) fillSlots(c Connection, queueNames []string, free uint, results chan<- threadResult) {
+	claimStart := time.Now()
+	jobs, undecodable, err := c.popJobsFrom(w.id, queueNames, free)
+	w.metrics.observeClaim(time.Since(claimStart))
+
+	if err != nil {
+		w.log.WithFields(logrus.Fields{"error": err}).Error("couldn't pop jobs")
+		return
+	}
+
+	runner := w.runner(c)
+
+	for _, failure := range undecodable {
+		runner.reject(failure.job, failure.err, failure.failErr)
+	}
+
+	for _, job := range jobs {
+		w.spawnThread(runner, job, results)
+	}
+}
+
+func (w *worker) runner(c Connection) jobRunner {
+	return jobRunner{c: c, log: w.log, emit: w.emit}
+}
+
+func (w *worker) reapThread(c Connection, result threadResult) {
+	w.sharedState.Lock()
+	thread := w.sharedState.activeThreads[result.id]
+	delete(w.sharedState.activeThreads, result.id)
+	w.sharedState.Unlock()
+
+	w.runner(c).complete(thread.job, result.outcome, thread.terminated)
+}
+
+// spawnThread starts a job on its own goroutine, which reports its outcome
+// to results. The thread is registered before the job is reported as started,
+// and reaped by the scheduler, which is also the caller. Jobs which can't be
+// started are failed by the runner.
+func (w *worker) spawnThread(runner jobRunner, job *Job, results chan<- threadResult) {
+	w.sharedState.Lock()
 	threadID := w.sharedState.counter
 	w.sharedState.counter++
+	w.sharedState.Unlock()
 
-	fmt.Printf("%v\n", threadID)
+	started := func(terminator chan struct{}) {
+		w.sharedState.Lock()
+		defer w.sharedState.Unlock()
 
-	callback := func(err error) {
-		results <- threadResult{
-			threadID,
-			err,
+		w.sharedState.activeThreads[threadID] = threadInfo{
+			job:        job,
+			startedAt:  time.Now(),
+			terminator: terminator,
 		}
 	}
 
-	terminator, err := performTaskAsync(job.TaskName, job.Parameters, callback)
+	_ = runner.start(job, started, func(outcome jobOutcome) {
+		results <- threadResult{threadID, outcome}
+	})
+}
+
+func (w *worker) setQuiet(quiet bool) {
+	w.sharedState.Lock()
+	defer w.sharedState.Unlock()
+	w.sharedState.quiet = quiet
+}
+
+func (w *worker) shutdown() {
+	select {
+	case w.globalTerminator <- apiShutdownError:
+	default:
+	}
+}
+
+// cancelJob signals a running job to stop by closing its terminator. This is
+// only possible if the job's task takes a terminator or a *TaskContext.
+func (w *worker) cancelJob(jobID uint) error {
+	cancelled, err := w.terminateThread(jobID)
 	if err != nil {
 		return err
 	}
 
-	w.sharedState.activeThreads[threadID] = threadInfo{
-		job:        job,
-		startedAt:  now,
-		terminator: terminator,
+	// Listeners run synchronously and may call back into the worker, so the
+	// event is emitted only once the shared state is unlocked
+	if cancelled != nil {
+		w.log.WithFields(logrus.Fields{"id": jobID, "taskName": cancelled.TaskName}).Info("cancelling job")
+		w.emit(Event{Kind: JobCancelledEvent, Job: cancelled})
 	}
 
 	return nil
 }
 
+// terminateThread closes the terminator of the thread running the job, and
+// returns the job if this call was the one to close it.
+func (w *worker) terminateThread(jobID uint) (*Job, error) {
+	w.sharedState.Lock()
+	defer w.sharedState.Unlock()
+
+	for threadID, thread := range w.sharedState.activeThreads {
+		if thread.job.ID != jobID {
+			continue
+		}
+
+		if thread.terminator == nil {
+			return nil, errJobNotCancellable
+		}
+
+		if thread.terminated {
+			return nil, nil
+		}
+
+		close(thread.terminator)
+		thread.terminated = true
+		w.sharedState.activeThreads[threadID] = thread
+
+		return thread.job, nil
+	}
+
+	return nil, errJobNotRunning
+}
+
 func (w *worker) heartbeat(c Connection) {
 	ticker := time.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 
 	for {
-		if err := c.beatWorker(w.id); err != nil {
+		err := c.beatWorker(w.id)
+		if err != nil {
 			w.log.WithFields(logrus.Fields{"error": err}).Error("worker heartbeat failed")
 		} else {
 			w.log.Info("heartbeat")
 		}
+		w.emit(Event{Kind: WorkerHeartbeatEvent, Error: err})
+
+		if err := w.stats.flush(c, time.Now()); err != nil {
+			w.log.WithFields(logrus.Fields{"error": err}).Error("failed to save job statistics")
+		}
 
 		select {
 		case <-w.subroutineTerminator:
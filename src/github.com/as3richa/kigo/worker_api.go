@@ -1,4 +1,194 @@
 package kigo
 
-func (w worker) apiHttpServer(addr string) {
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"time"
+
+	"github.com/braintree/manners"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type workerStatus struct {
+	ID          uint           `json:"id"`
+	Name        string         `json:"name"`
+	Queues      []string       `json:"queues"`
+	Concurrency uint           `json:"concurrency"`
+	Quiet       bool           `json:"quiet"`
+	Threads     []threadStatus `json:"threads"`
+}
+
+type threadStatus struct {
+	ThreadID  uint      `json:"threadId"`
+	JobID     uint      `json:"jobId"`
+	TaskName  string    `json:"taskName"`
+	QueueName string    `json:"queueName"`
+	StartedAt time.Time `json:"startedAt"`
+	Cancelled bool      `json:"cancelled"`
+}
+
+func (w *worker) apiHttpServer(addr string, enableProfiling bool, authenticate Authenticator) {
+	router := httprouter.New()
+	w.defineApiRoutes(router, authenticate)
+	if enableProfiling {
+		defineProfilingRoutes(router)
+	}
+
+	server := manners.NewWithServer(&http.Server{
+		Addr:           addr,
+		Handler:        router,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	})
+
+	go func() {
+		<-w.subroutineTerminator
+		server.Close()
+	}()
+
+	w.log.WithFields(logrus.Fields{"addr": addr}).Info("starting api server")
+	if err := server.ListenAndServe(); err != nil {
+		w.log.WithFields(logrus.Fields{"addr": addr, "error": err}).Error("api server failed")
+	}
+}
+
+// loopbackAddr reports whether addr only accepts connections from this host.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// guardControl refuses control requests sent by browsers on behalf of other
+// origins and, given an authenticator, those from callers without the admin
+// role.
+func guardControl(authenticate Authenticator, handle httprouter.Handle) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if authenticate != nil {
+			role, ok := authenticate(r)
+			if !ok {
+				rw.Header().Set("WWW-Authenticate", `Basic realm="kigo"`)
+				writeJSONError(rw, errUnauthenticated, http.StatusUnauthorized)
+				return
+			}
+			if role != WebfaceAdmin {
+				writeJSONError(rw, errReadOnly, http.StatusForbidden)
+				return
+			}
+		}
+
+		if crossOrigin(r) {
+			writeJSONError(rw, errCrossOrigin, http.StatusForbidden)
+			return
+		}
+
+		handle(rw, r, params)
+	}
+}
+
+func (w *worker) defineApiRoutes(router *httprouter.Router, authenticate Authenticator) {
+	metrics := func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.sharedState.Lock()
+		busy := uint(len(w.sharedState.activeThreads))
+		total := w.sharedState.concurrency
+		w.sharedState.Unlock()
+
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.metrics.write(rw, busy, total)
+	}
+
+	status := func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(rw, w.status())
+	}
+
+	quiet := func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.log.Info("quieting worker")
+		w.setQuiet(true)
+		writeJSON(rw, w.status())
+	}
+
+	resume := func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.log.Info("resuming worker")
+		w.setQuiet(false)
+		writeJSON(rw, w.status())
+	}
+
+	shutdown := func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.log.Info("shutdown requested")
+		w.shutdown()
+		rw.Write([]byte("{}\n"))
+	}
+
+	cancelJob := func(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id, ok := jobIDParam(rw, params)
+		if !ok {
+			return
+		}
+
+		switch err := w.cancelJob(id); err {
+		case nil:
+			rw.Write([]byte("{}\n"))
+		case errJobNotRunning:
+			writeJSONError(rw, err, http.StatusNotFound)
+		default:
+			writeJSONError(rw, err, http.StatusConflict)
+		}
+	}
+
+	router.GET("/metrics", metrics)
+	router.GET(apiPrefix+"/status", status)
+	router.POST(apiPrefix+"/quiet", guardControl(authenticate, quiet))
+	router.POST(apiPrefix+"/resume", guardControl(authenticate, resume))
+	router.POST(apiPrefix+"/shutdown", guardControl(authenticate, shutdown))
+	router.POST(apiPrefix+"/jobs/:id/cancel", guardControl(authenticate, cancelJob))
+}
+
+func defineProfilingRoutes(router *httprouter.Router) {
+	router.Handler("GET", "/debug/pprof/", http.HandlerFunc(pprof.Index))
+	router.Handler("GET", "/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	router.Handler("GET", "/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	router.Handler("GET", "/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	router.Handler("GET", "/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	for _, profile := range []string{"goroutine", "heap", "allocs", "threadcreate", "block", "mutex"} {
+		router.Handler("GET", "/debug/pprof/"+profile, pprof.Handler(profile))
+	}
+}
+
+func (w *worker) status() workerStatus {
+	w.sharedState.Lock()
+	defer w.sharedState.Unlock()
+
+	status := workerStatus{
+		ID:          w.id,
+		Name:        w.name,
+		Queues:      w.sharedState.queueNames,
+		Concurrency: w.sharedState.concurrency,
+		Quiet:       w.sharedState.quiet,
+		Threads:     make([]threadStatus, 0, len(w.sharedState.activeThreads)),
+	}
+
+	for threadID, thread := range w.sharedState.activeThreads {
+		status.Threads = append(status.Threads, threadStatus{
+			ThreadID:  threadID,
+			JobID:     thread.job.ID,
+			TaskName:  thread.job.TaskName,
+			QueueName: thread.job.QueueName,
+			StartedAt: thread.startedAt,
+			Cancelled: thread.terminated,
+		})
+	}
+
+	sort.Slice(status.Threads, func(i, j int) bool { return status.Threads[i].ThreadID < status.Threads[j].ThreadID })
+
+	return status
 }
@@ -0,0 +1,189 @@
+package kigo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func newTestWorker() *worker {
+	w := &worker{
+		log:              NullWorkerLogger,
+		events:           NewEventBus(),
+		metrics:          newWorkerMetrics(),
+		id:               3,
+		name:             "test/1",
+		globalTerminator: make(chan error, 4),
+	}
+	w.sharedState.queueNames = []string{"default"}
+	w.sharedState.concurrency = 2
+	w.sharedState.activeThreads = map[uint]threadInfo{
+		0: {job: &Job{ID: 10, TaskName: "cancellable"}, startedAt: time.Now(), terminator: make(chan struct{})},
+		1: {job: &Job{ID: 11, TaskName: "stubborn"}, startedAt: time.Now()},
+	}
+	return w
+}
+
+func serveWorkerApi(w *worker, method string, path string) *httptest.ResponseRecorder {
+	router := httprouter.New()
+	w.defineApiRoutes(router, nil)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func TestWorkerApiStatusAndQuiet(t *testing.T) {
+	w := newTestWorker()
+
+	response := serveWorkerApi(w, "POST", "/api/quiet")
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, response.Code)
+	}
+
+	var status workerStatus
+	expectSuccess(t, json.Unmarshal(serveWorkerApi(w, "GET", "/api/status").Body.Bytes(), &status))
+
+	if !status.Quiet || status.Name != "test/1" || len(status.Threads) != 2 || status.Threads[0].JobID != 10 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	if w.freeSlots() != 0 {
+		t.Error("expected a quiet worker to have no free slots")
+	}
+
+	serveWorkerApi(w, "POST", "/api/resume")
+	w.sharedState.activeThreads = map[uint]threadInfo{}
+	if w.freeSlots() != 2 {
+		t.Error("expected a resumed worker to have free slots")
+	}
+}
+
+func TestWorkerApiCancelJob(t *testing.T) {
+	w := newTestWorker()
+
+	var cancelled []uint
+	w.events.Subscribe(func(event Event) {
+		if event.Kind == JobCancelledEvent {
+			// Listeners may inspect the worker, so the event mustn't be
+			// emitted with its state locked
+			w.freeSlots()
+			cancelled = append(cancelled, event.Job.ID)
+		}
+	})
+
+	if response := serveWorkerApi(w, "POST", "/api/jobs/10/cancel"); response.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, response.Code)
+	}
+
+	select {
+	case <-w.sharedState.activeThreads[0].terminator:
+	default:
+		t.Error("expected terminator to be closed")
+	}
+
+	if response := serveWorkerApi(w, "POST", "/api/jobs/10/cancel"); response.Code != http.StatusOK {
+		t.Errorf("expected repeated cancellation to succeed, got %v", response.Code)
+	}
+
+	if response := serveWorkerApi(w, "POST", "/api/jobs/11/cancel"); response.Code != http.StatusConflict {
+		t.Errorf("expected %v, got %v", http.StatusConflict, response.Code)
+	}
+
+	if response := serveWorkerApi(w, "POST", "/api/jobs/12/cancel"); response.Code != http.StatusNotFound {
+		t.Errorf("expected %v, got %v", http.StatusNotFound, response.Code)
+	}
+
+	if len(cancelled) != 1 || cancelled[0] != 10 {
+		t.Errorf("expected a single cancellation event for job 10, got %v", cancelled)
+	}
+}
+
+func TestWorkerApiShutdown(t *testing.T) {
+	w := newTestWorker()
+	serveWorkerApi(w, "POST", "/api/shutdown")
+
+	select {
+	case err := <-w.globalTerminator:
+		if err != apiShutdownError {
+			t.Errorf("expected %v, got %v", apiShutdownError, err)
+		}
+	default:
+		t.Error("expected shutdown to trigger the global terminator")
+	}
+}
+
+func TestWorkerApiAuthentication(t *testing.T) {
+	w := newTestWorker()
+
+	router := httprouter.New()
+	w.defineApiRoutes(router, BearerTokenAuthenticator(map[string]WebfaceRole{
+		"viewer": WebfaceReadOnly,
+		"admin":  WebfaceAdmin,
+	}))
+
+	serve := func(method string, path string, token string, origin string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		origin string
+		code   int
+	}{
+		{"GET", "/api/status", "", "", http.StatusOK},
+		{"POST", "/api/quiet", "", "", http.StatusUnauthorized},
+		{"POST", "/api/quiet", "wrong", "", http.StatusUnauthorized},
+		{"POST", "/api/shutdown", "viewer", "", http.StatusForbidden},
+		{"POST", "/api/jobs/10/cancel", "viewer", "", http.StatusForbidden},
+		{"POST", "/api/quiet", "admin", "http://evil.example", http.StatusForbidden},
+		{"POST", "/api/quiet", "admin", "", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		if code := serve(c.method, c.path, c.token, c.origin); code != c.code {
+			t.Errorf("%s %s with token %q and origin %q: expected %v, got %v", c.method, c.path, c.token, c.origin, c.code, code)
+		}
+	}
+
+	select {
+	case err := <-w.globalTerminator:
+		t.Errorf("expected unauthorized shutdown to be refused, got %v", err)
+	default:
+	}
+}
+
+func TestWorkerRefusesUnauthenticatedPublicApi(t *testing.T) {
+	for addr, loopback := range map[string]bool{
+		"127.0.0.1:32600": true,
+		"[::1]:32600":     true,
+		"localhost:32600": true,
+		"0.0.0.0:32600":   false,
+		":32600":          false,
+		"10.0.0.5:32600":  false,
+	} {
+		if loopbackAddr(addr) != loopback {
+			t.Errorf("expected loopbackAddr(%q) to be %v", addr, loopback)
+		}
+	}
+
+	err := Connection{}.RunWorkerWithOptions(nil, 1, &WorkerOptions{ApiAddr: "0.0.0.0:32600"})
+	if err != ErrInsecureApiAddr {
+		t.Errorf("expected %v, got %v", ErrInsecureApiAddr, err)
+	}
+}
@@ -0,0 +1,128 @@
+package kigo
+
+import "time"
+
+// Workers which haven't heartbeated for workerStaleThreshold are stale, and
+// those which haven't heartbeated for workerDeadThreshold are presumed dead.
+const workerStaleThreshold = 3 * heartbeatInterval
+const workerDeadThreshold = 10 * heartbeatInterval
+
+type WorkerStatus string
+
+const (
+	WorkerHealthy WorkerStatus = "healthy"
+	WorkerStale   WorkerStatus = "stale"
+	WorkerDead    WorkerStatus = "dead"
+)
+
+type WorkerInfo struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Queues      []string `json:"queues"`
+	Concurrency uint     `json:"concurrency"`
+
+	StartedAt   time.Time  `json:"startedAt"`
+	HeartbeatAt time.Time  `json:"heartbeatAt"`
+	StoppedAt   *time.Time `json:"stoppedAt"`
+
+	Status WorkerStatus `json:"status"`
+
+	// Jobs are the jobs currently assigned to the worker.
+	Jobs []JobInfo `json:"jobs"`
+
+	// Orphaned is set when the worker isn't healthy but still holds jobs,
+	// which most likely will never finish.
+	Orphaned bool `json:"orphaned"`
+}
+
+func workerStatusAt(workerRecord workerModel, now time.Time) WorkerStatus {
+	age := now.Sub(workerRecord.HeartbeatAt)
+	switch {
+	case workerRecord.StoppedAt != nil || age >= workerDeadThreshold:
+		return WorkerDead
+	case age >= workerStaleThreshold:
+		return WorkerStale
+	default:
+		return WorkerHealthy
+	}
+}
+
+func (c Connection) Workers() ([]WorkerInfo, error) {
+	if err := c.requireSQL(); err != nil {
+		return nil, err
+	}
+
+	var workerRecords []workerModel
+	if err := c.db.Preload("Queues").Order("id ASC").Find(&workerRecords).Error; err != nil {
+		return nil, err
+	}
+	return c.workerInfos(workerRecords)
+}
+
+func (c Connection) FindWorker(id uint) (*WorkerInfo, error) {
+	if err := c.requireSQL(); err != nil {
+		return nil, err
+	}
+
+	var workerRecord workerModel
+	if err := c.db.Preload("Queues").Where("id = ?", id).First(&workerRecord).Error; err != nil {
+		return nil, err
+	}
+
+	infos, err := c.workerInfos([]workerModel{workerRecord})
+	if err != nil {
+		return nil, err
+	}
+
+	return &infos[0], nil
+}
+
+func (c Connection) workerInfos(workerRecords []workerModel) ([]WorkerInfo, error) {
+	now := time.Now()
+
+	infos := make([]WorkerInfo, len(workerRecords))
+	byID := map[uint]*WorkerInfo{}
+	ids := make([]uint, len(workerRecords))
+
+	for i, workerRecord := range workerRecords {
+		queues := make([]string, len(workerRecord.Queues))
+		for j, queueRecord := range workerRecord.Queues {
+			queues[j] = queueRecord.Name
+		}
+
+		infos[i] = WorkerInfo{
+			ID:          workerRecord.ID,
+			Name:        workerRecord.Name,
+			Queues:      queues,
+			Concurrency: workerRecord.Concurrency,
+			StartedAt:   workerRecord.StartedAt,
+			HeartbeatAt: workerRecord.HeartbeatAt,
+			StoppedAt:   workerRecord.StoppedAt,
+			Status:      workerStatusAt(workerRecord, now),
+			Jobs:        []JobInfo{},
+		}
+
+		byID[workerRecord.ID] = &infos[i]
+		ids[i] = workerRecord.ID
+	}
+
+	if len(ids) == 0 {
+		return infos, nil
+	}
+
+	var jobRecords []jobModel
+	if err := c.db.Where("worker_id IN (?)", ids).Order("id ASC").Find(&jobRecords).Error; err != nil {
+		return nil, err
+	}
+
+	for _, jobRecord := range jobRecords {
+		info := byID[*jobRecord.WorkerID]
+		info.Jobs = append(info.Jobs, jobRecord.info())
+	}
+
+	for i := range infos {
+		infos[i].Orphaned = infos[i].Status != WorkerHealthy && len(infos[i].Jobs) > 0
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,53 @@
+package kigo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerStatus(t *testing.T) {
+	now := time.Now()
+	stoppedAt := now
+
+	cases := []struct {
+		worker workerModel
+		status WorkerStatus
+	}{
+		{workerModel{HeartbeatAt: now.Add(-heartbeatInterval)}, WorkerHealthy},
+		{workerModel{HeartbeatAt: now.Add(-workerStaleThreshold)}, WorkerStale},
+		{workerModel{HeartbeatAt: now.Add(-workerDeadThreshold)}, WorkerDead},
+		{workerModel{HeartbeatAt: now, StoppedAt: &stoppedAt}, WorkerDead},
+	}
+
+	for _, c := range cases {
+		if status := workerStatusAt(c.worker, now); status != c.status {
+			t.Errorf("expected %v for heartbeat %v ago, got %v", c.status, now.Sub(c.worker.HeartbeatAt), status)
+		}
+	}
+}
+
+func TestWorkersReportHeldJobs(t *testing.T) {
+	withConnection(t, func(c Connection) {
+		workerID, err := c.createWorker("test/1", []string{"alpha"}, 2)
+		expectSuccess(t, err)
+
+		_, err = c.pushJobTo("alpha", "held", []interface{}{}, time.Now())
+		expectSuccess(t, err)
+
+		jobs, _, err := c.popJobsFrom(workerID, []string{"alpha"}, 2)
+		expectSuccess(t, err)
+
+		expectSuccess(t, c.db.Model(&workerModel{}).Where("id = ?", workerID).Update("heartbeat_at", time.Now().Add(-workerDeadThreshold)).Error)
+
+		workers, err := c.Workers()
+		expectSuccess(t, err)
+
+		if len(workers) != 1 || len(workers[0].Jobs) != 1 || workers[0].Jobs[0].ID != jobs[0].ID {
+			t.Fatalf("expected one worker holding job %d, got %+v", jobs[0].ID, workers)
+		}
+
+		if workers[0].Status != WorkerDead || !workers[0].Orphaned || workers[0].Queues[0] != "alpha" {
+			t.Errorf("expected a dead worker orphaning its job, got %+v", workers[0])
+		}
+	})
+}